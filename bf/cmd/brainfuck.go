@@ -1,20 +1,44 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 
 	"github.com/MarcinKonowalczyk/runbf/bf"
 )
 
 var filename string
-
-func init() {
-	flag.StringVar(&filename, "file", "", "brainfuck source file")
-}
+var profile string
+var coverage string
+var coverageSource string
+var logLevel slog.Level
+var options = bf.DefaultOptions()
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(runTests(os.Args[2:]))
+	}
+
+	// Flags win over the environment
+	if err := options.ApplyEnv(os.Environ()); err != nil {
+		fmt.Println("Invalid environment:", err)
+		os.Exit(2)
+	}
+	level, err := bf.LogLevelFromEnv()
+	if err != nil {
+		fmt.Printf("Invalid environment: %s: %v\n", bf.LogLevelEnv, err)
+		os.Exit(2)
+	}
+	flag.StringVar(&filename, "file", "", "brainfuck source file")
+	flag.StringVar(&profile, "profile", "", "write a pprof profile of the instructions executed to this file")
+	flag.StringVar(&coverage, "coverage", "", "write a json coverage report to this file, merged with the report already in it")
+	flag.StringVar(&coverageSource, "coverage-source", "", "write the source annotated with the (merged) coverage to this file")
+	flag.TextVar(&logLevel, "log-level", level, "log level (debug, info, warn or error)")
+	options.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 	if filename == "" {
 		fmt.Println("Please provide a filename using the -file flag.")
@@ -26,5 +50,86 @@ func main() {
 		panic(err)
 	}
 
-	bf.Run(string(input))
+	interpreter := bf.NewInterpreter(bf.Lex(bf.PreLex(string(input))), os.Stdin, os.Stdout)
+	interpreter.SetLogger(bf.NewLogger(os.Stderr, logLevel, false).With("file", filename))
+	if err := interpreter.SetOptions(options); err != nil {
+		fmt.Println("Invalid options:", err)
+		os.Exit(2)
+	}
+	if profile != "" || coverage != "" || coverageSource != "" {
+		interpreter.EnableProfiling()
+	}
+	run_err := interpreter.RunContext(context.Background())
+
+	if profile != "" {
+		if err := writeProfile(interpreter, string(input)); err != nil {
+			fmt.Println("Error writing profile:", err)
+		}
+	}
+	if coverage != "" || coverageSource != "" {
+		if err := writeCoverage(interpreter, string(input)); err != nil {
+			fmt.Println("Error writing coverage:", err)
+		}
+	}
+
+	if run_err != nil {
+		fmt.Println("Error running brainfuck:", run_err)
+		os.Exit(1)
+	}
+}
+
+// Add the run to the coverage report and write the report and the annotated
+// source
+func writeCoverage(interpreter *bf.Interpreter, source string) error {
+	cov := bf.NewCoverage(filename, source)
+	if err := cov.Add(interpreter.Profile()); err != nil {
+		return err
+	}
+
+	if coverage != "" {
+		if f, err := os.Open(coverage); err == nil {
+			previous, err := bf.ReadCoverage(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", coverage, err)
+			}
+			if err := cov.Merge(previous); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := writeFile(coverage, cov.WriteJSON); err != nil {
+			return err
+		}
+	}
+
+	if coverageSource != "" {
+		return writeFile(coverageSource, func(w io.Writer) error {
+			return cov.WriteAnnotated(w, source)
+		})
+	}
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeProfile(interpreter *bf.Interpreter, source string) error {
+	f, err := os.Create(profile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return interpreter.Profile().WritePprof(f, filename, bf.Positions(source))
 }
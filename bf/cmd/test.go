@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/bf/golden"
+)
+
+// `brainfuck test [flags] [patterns]`. Runs the programs matched by the
+// patterns against their golden files and returns the exit code.
+func runTests(args []string) int {
+	options := bf.DefaultOptions()
+	if err := options.ApplyEnv(os.Environ()); err != nil {
+		fmt.Println("Invalid environment:", err)
+		return 2
+	}
+
+	var update, verbose bool
+	var junit string
+	var timeout time.Duration
+	my_flagset := flag.NewFlagSet("test", flag.ExitOnError)
+	my_flagset.Usage = func() {
+		fmt.Fprintln(my_flagset.Output(), "Usage: brainfuck test [flags] [./dir/... | ./dir | file.bf]")
+		my_flagset.PrintDefaults()
+	}
+	my_flagset.BoolVar(&update, "update", false, "write the outputs of the programs to their golden files")
+	my_flagset.BoolVar(&verbose, "v", false, "list all cases, not just the failing ones")
+	my_flagset.StringVar(&junit, "junit", "", "also write the results as JUnit XML to this file")
+	my_flagset.DurationVar(&timeout, "timeout", 10*time.Second, "fail a case which runs for longer than this (0 for no limit)")
+	options.RegisterFlags(my_flagset)
+	if err := my_flagset.Parse(args); err != nil {
+		return 2
+	}
+	if err := options.Validate(); err != nil {
+		fmt.Println("Invalid options:", err)
+		return 2
+	}
+
+	patterns := my_flagset.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	programs, err := golden.Find(patterns)
+	if err != nil {
+		fmt.Println("Error finding programs:", err)
+		return 2
+	}
+
+	var suites []golden.SuiteResult
+	for _, program := range programs {
+		suites = append(suites, runSuite(program, options, timeout, update))
+	}
+
+	golden.WriteText(os.Stdout, suites, verbose)
+
+	if junit != "" {
+		if err := writeFile(junit, func(w io.Writer) error {
+			return golden.WriteJUnit(w, suites)
+		}); err != nil {
+			fmt.Println("Error writing JUnit report:", err)
+			return 2
+		}
+	}
+
+	for _, suite := range suites {
+		if !suite.Passed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+func runSuite(program string, options bf.Options, timeout time.Duration, update bool) (result golden.SuiteResult) {
+	result.Program = program
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	suite, err := golden.Load(program, update)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Results, err = suite.Run(context.Background(), options, timeout)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if update {
+		if err := suite.Update(result.Results); err != nil {
+			result.Err = fmt.Errorf("updating golden files: %w", err)
+			return result
+		}
+		// the outputs are the golden ones now
+		for i, r := range result.Results {
+			if r.Err == nil {
+				result.Results[i].Case.Want = r.Got
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package bf
+
+import "context"
+
+// Compiled part of a program. It returns an error to stop the program, which
+// might be errEndOfInput or errCancelled.
+type compiled func(ctx context.Context, i *Interpreter) error
+
+// Compile the ops to a function. Loops become Go loops over the functions of
+// their body, so nothing looks up where a bracket jumps to.
+func compile(ops []op) compiled {
+	return compileBlock(ops, 0, len(ops))
+}
+
+// Compile ops[from:to]
+func compileBlock(ops []op, from, to int) compiled {
+	var steps []compiled
+	for k := from; k < to; k++ {
+		o := &ops[k]
+		switch {
+		case o.cmd == LoopStart && o.jump > k:
+			steps = append(steps, compileLoop(o, &ops[o.jump], compileBlock(ops, k+1, o.jump)))
+			k = o.jump
+		case o.cmd == LoopStart || o.cmd == LoopEnd:
+			// Unmatched, so it carries on with the next op either way
+			steps = append(steps, func(ctx context.Context, i *Interpreter) error {
+				_, err := i.bracket(o)
+				return err
+			})
+		default:
+			steps = append(steps, func(ctx context.Context, i *Interpreter) error {
+				return i.exec(o)
+			})
+		}
+	}
+	return func(ctx context.Context, i *Interpreter) error {
+		for _, step := range steps {
+			if err := step(ctx, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func compileLoop(start, end *op, body compiled) compiled {
+	return func(ctx context.Context, i *Interpreter) error {
+		if skip, err := i.bracket(start); err != nil || skip {
+			return err
+		}
+		for {
+			if err := body(ctx, i); err != nil {
+				return err
+			}
+			if again, err := i.bracket(end); err != nil || !again {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				i.program_ptr = start.pc + 1
+				return errCancelled
+			default:
+			}
+			i.publishStatsDue()
+		}
+	}
+}
+
+// Run the program as a compiled function
+func (i *Interpreter) runCompiled(ctx context.Context) error {
+	if err := compile(optimize(i.Program))(ctx, i); err != nil {
+		return stopError(err)
+	}
+	i.program_ptr = uint32(len(i.Program))
+	return nil
+}
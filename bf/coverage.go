@@ -0,0 +1,210 @@
+package bf
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Coverage of a program, accumulated over one or more runs
+type Coverage struct {
+	File      string
+	Program   []Command
+	Positions []Position
+	// Number of executions of each instruction, over all the runs
+	Counts []uint64
+	Runs   int
+}
+
+// Empty coverage of the program in the source
+func NewCoverage(file string, source string) *Coverage {
+	program := Lex(PreLex(source))
+	return &Coverage{
+		File:      file,
+		Program:   program,
+		Positions: Positions(source),
+		Counts:    make([]uint64, len(program)),
+	}
+}
+
+// Add the counts of a run of the program
+func (c *Coverage) Add(profile *Profile) error {
+	if len(profile.Counts) != len(c.Counts) {
+		return fmt.Errorf("profile has %d instructions, expected %d", len(profile.Counts), len(c.Counts))
+	}
+	for j, count := range profile.Counts {
+		c.Counts[j] += count
+	}
+	c.Runs++
+	return nil
+}
+
+// Add the counts of another coverage of the same program
+func (c *Coverage) Merge(other *Coverage) error {
+	if len(other.Program) != len(c.Program) {
+		return fmt.Errorf("coverage of %s is of a different program", other.File)
+	}
+	for j := range c.Program {
+		if other.Program[j] != c.Program[j] || other.Positions[j] != c.Positions[j] {
+			return fmt.Errorf("coverage of %s is of a different program (at %s)", other.File, c.Positions[j])
+		}
+	}
+	for j, count := range other.Counts {
+		c.Counts[j] += count
+	}
+	c.Runs += other.Runs
+	return nil
+}
+
+// Machine readable coverage report
+type CoverageReport struct {
+	File         string                `json:"file"`
+	Runs         int                   `json:"runs"`
+	Summary      CoverageSummary       `json:"summary"`
+	Instructions []InstructionCoverage `json:"instructions"`
+	Loops        []LoopCoverage        `json:"loops"`
+}
+
+type CoverageSummary struct {
+	Instructions        int `json:"instructions"`
+	InstructionsCovered int `json:"instructions_covered"`
+	Loops               int `json:"loops"`
+	LoopBodiesCovered   int `json:"loop_bodies_covered"`
+}
+
+type InstructionCoverage struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Command string `json:"command"`
+	Count   uint64 `json:"count"`
+}
+
+type LoopCoverage struct {
+	Start      Position `json:"start"`
+	End        Position `json:"end"`
+	Entries    uint64   `json:"entries"`
+	Iterations uint64   `json:"iterations"`
+	// Whether the body of the loop ran at least once
+	BodyCovered bool `json:"body_covered"`
+}
+
+func (c *Coverage) Report() CoverageReport {
+	report := CoverageReport{
+		File:         c.File,
+		Runs:         c.Runs,
+		Instructions: []InstructionCoverage{},
+		Loops:        []LoopCoverage{},
+	}
+	for j, command := range c.Program {
+		report.Instructions = append(report.Instructions, InstructionCoverage{
+			Line:    c.Positions[j].Line,
+			Column:  c.Positions[j].Column,
+			Command: command.String(),
+			Count:   c.Counts[j],
+		})
+		report.Summary.Instructions++
+		if c.Counts[j] > 0 {
+			report.Summary.InstructionsCovered++
+		}
+	}
+
+	profile := &Profile{Program: c.Program, Counts: c.Counts}
+	for _, loop := range profile.Loops() {
+		// the instruction after the `[` runs (or the `]` of an empty loop)
+		// whenever the body does
+		covered := c.Counts[loop.Start+1] > 0
+		report.Loops = append(report.Loops, LoopCoverage{
+			Start:       c.Positions[loop.Start],
+			End:         c.Positions[loop.End],
+			Entries:     loop.Entries,
+			Iterations:  loop.Iterations,
+			BodyCovered: covered,
+		})
+		report.Summary.Loops++
+		if covered {
+			report.Summary.LoopBodiesCovered++
+		}
+	}
+	return report
+}
+
+func (c *Coverage) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c.Report())
+}
+
+// Read a coverage written by WriteJSON
+func ReadCoverage(r io.Reader) (*Coverage, error) {
+	var report CoverageReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	c := &Coverage{File: report.File, Runs: report.Runs}
+	for _, instruction := range report.Instructions {
+		commands := Lex(instruction.Command)
+		if len(commands) != 1 {
+			return nil, fmt.Errorf("invalid command %q at %d:%d", instruction.Command, instruction.Line, instruction.Column)
+		}
+		c.Program = append(c.Program, commands[0])
+		c.Positions = append(c.Positions, Position{Line: instruction.Line, Column: instruction.Column})
+		c.Counts = append(c.Counts, instruction.Count)
+	}
+	return c, nil
+}
+
+// Write the source with the execution count of each line in front of it, in
+// the style of gcov. The count of a line is that of its most executed
+// instruction, so a line is only marked with ##### when none of its
+// instructions ran. Any line with instructions which never ran is followed by
+// a line which points at them with ^. Lines without instructions have a -
+// instead of a count.
+func (c *Coverage) WriteAnnotated(w io.Writer, source string) error {
+	type lineCoverage struct {
+		count     uint64
+		uncovered []int // columns
+	}
+	lines := map[int]*lineCoverage{}
+	for j, pos := range c.Positions {
+		line, ok := lines[pos.Line]
+		if !ok {
+			line = &lineCoverage{}
+			lines[pos.Line] = line
+		}
+		line.count = max(line.count, c.Counts[j])
+		if c.Counts[j] == 0 {
+			line.uncovered = append(line.uncovered, pos.Column)
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	for n, text := range strings.Split(strings.TrimSuffix(source, "\n"), "\n") {
+		line, ok := lines[n+1]
+		switch {
+		case !ok:
+			fmt.Fprintf(out, "%9s:%5d:%s\n", "-", n+1, text)
+		case line.count == 0:
+			fmt.Fprintf(out, "%9s:%5d:%s\n", "#####", n+1, text)
+		default:
+			fmt.Fprintf(out, "%9d:%5d:%s\n", line.count, n+1, text)
+		}
+		if ok && len(line.uncovered) > 0 {
+			// keep the tabs, so that the marks line up with the source
+			runes := []rune(text)
+			marks := make([]rune, line.uncovered[len(line.uncovered)-1])
+			for k := range marks {
+				marks[k] = ' '
+				if runes[k] == '\t' {
+					marks[k] = '\t'
+				}
+			}
+			for _, column := range line.uncovered {
+				marks[column-1] = '^'
+			}
+			fmt.Fprintf(out, "%9s:%5s:%s\n", "", "", string(marks))
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,76 @@
+package bf_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+// Read one byte. Print it if it's 1, skip the loop otherwise.
+const coverageSource = ",\n[\n\t-.\n]\n"
+
+func runCoverage(t *testing.T, input string) *bf.Coverage {
+	interpreter := bf.NewInterpreter(bf.Lex(bf.PreLex(coverageSource)), strings.NewReader(input), &strings.Builder{})
+	interpreter.EnableProfiling()
+	utils.AssertNoError(t, interpreter.Run())
+
+	coverage := bf.NewCoverage("test.bf", coverageSource)
+	utils.AssertNoError(t, coverage.Add(interpreter.Profile()))
+	return coverage
+}
+
+func TestCoverage_Report(t *testing.T) {
+	report := runCoverage(t, "\x00").Report()
+	utils.AssertEqual(t, report.Runs, 1)
+	utils.AssertEqual(t, report.Summary, bf.CoverageSummary{
+		Instructions:        5,
+		InstructionsCovered: 2,
+		Loops:               1,
+		LoopBodiesCovered:   0,
+	})
+	utils.AssertEqual(t, report.Loops[0].Start, bf.Position{Line: 2, Column: 1})
+	utils.AssertEqual(t, report.Loops[0].End, bf.Position{Line: 4, Column: 1})
+}
+
+func TestCoverage_Merge(t *testing.T) {
+	coverage := runCoverage(t, "\x00")
+	utils.AssertNoError(t, coverage.Merge(runCoverage(t, "\x01")))
+
+	report := coverage.Report()
+	utils.AssertEqual(t, report.Runs, 2)
+	utils.AssertEqual(t, report.Summary.InstructionsCovered, 5)
+	utils.AssertEqual(t, report.Summary.LoopBodiesCovered, 1)
+
+	other := bf.NewCoverage("other.bf", "+")
+	utils.AssertError(t, coverage.Merge(other))
+}
+
+func TestCoverage_ReadWrite(t *testing.T) {
+	coverage := runCoverage(t, "\x01")
+	var buf bytes.Buffer
+	utils.AssertNoError(t, coverage.WriteJSON(&buf))
+
+	read, err := bf.ReadCoverage(&buf)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, read.File, "test.bf")
+	utils.AssertEqual(t, read.Runs, 1)
+	utils.AssertEqualArrays(t, read.Program, coverage.Program)
+	utils.AssertEqualArrays(t, read.Positions, coverage.Positions)
+	utils.AssertEqualArrays(t, read.Counts, coverage.Counts)
+}
+
+func TestCoverage_WriteAnnotated(t *testing.T) {
+	var buf bytes.Buffer
+	utils.AssertNoError(t, runCoverage(t, "\x00").WriteAnnotated(&buf, coverageSource))
+	expected := "" +
+		"        1:    1:,\n" +
+		"        1:    2:[\n" +
+		"    #####:    3:\t-.\n" +
+		"         :     :\t^^\n" +
+		"    #####:    4:]\n" +
+		"         :     :^\n"
+	utils.AssertEqual(t, buf.String(), expected)
+}
@@ -0,0 +1,198 @@
+package bf_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+// Limits which keep a single fuzz run fast
+const (
+	fuzzMaxSteps = 10_000
+	fuzzTapeSize = 64
+)
+
+// Programs to start the fuzzers from
+func addSeeds(f *testing.F, add func(program string)) {
+	for _, program := range []string{"", "+", "[", "]", "][", "+[]", "+[-]", "[[]]", "+[>+<-]>.", ",[.,]", "<.", "+[<+]", "+[-[+]]"} {
+		add(program)
+	}
+	for _, name := range []string{"hello.bf", "sierpinski.bf"} {
+		source, err := os.ReadFile("programs/" + name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		add(string(source))
+	}
+}
+
+func FuzzLex(f *testing.F) {
+	addSeeds(f, func(program string) { f.Add(program + " comment\n") })
+	f.Fuzz(func(t *testing.T, source string) {
+		prelexed := bf.PreLex(source)
+		for _, c := range prelexed {
+			utils.Assert(t, strings.ContainsRune("+-<>.,[]", c), "PreLex kept "+string(c))
+		}
+
+		program := bf.Lex(source)
+		utils.AssertEqualArrays(t, bf.Lex(prelexed), program)
+		utils.AssertEqual(t, len(bf.Positions(source)), len(program))
+
+		// the commands print back to the prelexed source
+		var printed strings.Builder
+		for _, c := range program {
+			printed.WriteString(c.String())
+		}
+		utils.AssertEqual(t, printed.String(), prelexed)
+	})
+}
+
+// What a run of a program did
+type engineResult struct {
+	output string
+	err    error
+	tape   []uint32
+	stats  bf.Stats
+	// execution counts, when profiling
+	counts []uint64
+}
+
+func runEngine(program []bf.Command, input []byte, options bf.Options, profile bool) engineResult {
+	var output strings.Builder
+	interpreter := bf.NewInterpreter(program, strings.NewReader(string(input)), &output)
+	if err := interpreter.SetOptions(options); err != nil {
+		panic(err)
+	}
+	if profile {
+		interpreter.EnableProfiling()
+	}
+	err := interpreter.RunContext(context.Background())
+
+	tape := make([]uint32, interpreter.MemoryLength())
+	for j := range tape {
+		tape[j] = interpreter.At(int32(j))
+	}
+	result := engineResult{output.String(), err, tape, interpreter.Stats(), nil}
+	if profile {
+		result.counts = interpreter.Profile().Counts
+	}
+	return result
+}
+
+// Options for a fuzz run, picked by a byte of the fuzz input
+func fuzzOptions(choice byte) bf.Options {
+	options := bf.DefaultOptions()
+	options.MaxSteps = fuzzMaxSteps
+	options.TapeSize = fuzzTapeSize
+	options.CellWidth = bf.CellWidths[int(choice)%len(bf.CellWidths)]
+	options.EOFMode = bf.EOFModes[int(choice/4)%len(bf.EOFModes)]
+	return options
+}
+
+func FuzzRunContext(f *testing.F) {
+	addSeeds(f, func(program string) { f.Add(program, []byte("input"), byte(0)) })
+	f.Fuzz(func(t *testing.T, source string, input []byte, choice byte) {
+		options := fuzzOptions(choice)
+		result := runEngine(bf.Lex(source), input, options, false)
+		if result.err != nil && !errors.Is(result.err, bf.ErrStepLimit) {
+			t.Fatalf("unexpected error: %v", result.err)
+		}
+		utils.Assert(t, result.stats.Instructions <= fuzzMaxSteps, "ran past the step limit")
+		utils.Assert(t, result.stats.BytesRead <= uint64(len(input)), "read more than the input")
+		for _, cell := range result.tape {
+			utils.Assert(t, uint64(cell) < 1<<options.CellWidth, "cell wider than the cell width")
+		}
+	})
+}
+
+// Run the program with every engine in bf.Engines (the basic and fast
+// interpreters, the optimized instructions and the compiled program) and check
+// that they all do the same as the basic one, down to the profile when
+// profiling.
+func checkEngines(t *testing.T, program []bf.Command, input []byte, options bf.Options, profile bool) {
+	t.Helper()
+	var want engineResult
+	for k, engine := range bf.Engines {
+		options.Engine = engine
+		got := runEngine(program, input, options, profile)
+		if k == 0 {
+			want = got
+			continue
+		}
+		if got.output != want.output || !errors.Is(got.err, want.err) || got.stats != want.stats ||
+			!slices.Equal(got.tape, want.tape) || !slices.Equal(got.counts, want.counts) {
+			t.Fatalf("engine %s differs from %s on %q (input %q):\n got %+v\nwant %+v",
+				engine, bf.Engines[0], commandsString(program), input, got, want)
+		}
+	}
+}
+
+func commandsString(program []bf.Command) string {
+	var s strings.Builder
+	for _, c := range program {
+		s.WriteString(c.String())
+	}
+	return s.String()
+}
+
+// Differential test of the engines on the fuzz inputs
+func FuzzEngines(f *testing.F) {
+	addSeeds(f, func(program string) { f.Add(program, []byte("input"), byte(0)) })
+	f.Fuzz(func(t *testing.T, source string, input []byte, choice byte) {
+		checkEngines(t, bf.Lex(source), input, fuzzOptions(choice), choice&1 == 1)
+	})
+}
+
+// Random program, with mostly matched brackets, and runs of commands and
+// loops which clear a cell for the optimized engines
+func randomProgram(r *rand.Rand, length int) []bf.Command {
+	commands := []bf.Command{bf.Increment, bf.Decrement, bf.Left, bf.Right, bf.Output, bf.Input}
+	var program []bf.Command
+	depth := 0
+	for range length {
+		switch n := r.Intn(24); {
+		case n == 20:
+			program = append(program, bf.LoopStart, commands[r.Intn(2)], bf.LoopEnd)
+		case n > 20:
+			c := commands[r.Intn(4)]
+			for range 2 + r.Intn(70) {
+				program = append(program, c)
+			}
+		case n < 3:
+			program = append(program, bf.LoopStart)
+			depth++
+		case n < 6 && depth > 0:
+			program = append(program, bf.LoopEnd)
+			depth--
+		case n == 6:
+			// the occasional unmatched bracket
+			program = append(program, bf.LoopEnd)
+		default:
+			program = append(program, commands[r.Intn(len(commands))])
+		}
+	}
+	for ; depth > 0; depth-- {
+		program = append(program, bf.LoopEnd)
+	}
+	return program
+}
+
+// Differential test of the engines on random programs, so that it runs without
+// -fuzz too
+func TestEngines_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for range 2000 {
+		program := randomProgram(r, 1+r.Intn(40))
+		input := make([]byte, r.Intn(8))
+		r.Read(input)
+		choice := byte(r.Intn(256))
+		checkEngines(t, program, input, fuzzOptions(choice), choice&1 == 1)
+	}
+}
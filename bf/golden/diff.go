@@ -0,0 +1,146 @@
+package golden
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of context around the changes in a diff
+const diffContext = 2
+
+// Largest LCS table Diff builds. The outputs of a program can be big, so past
+// this Diff only shows the first line which differs.
+const maxDiffCells = 1 << 20
+
+// Line by line diff of want and got. Removed lines (only in want) start with
+// -, added lines (only in got) with + and the context around them with a space.
+// A changed last line without a newline is followed by `\ no newline at end`.
+// Changed lines are quoted if they're empty, have leading or trailing
+// whitespace or control characters, which would be invisible otherwise.
+func Diff(want string, got string) string {
+	a := splitLines(want)
+	b := splitLines(got)
+
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+
+	// Only the lines between the common prefix and suffix need diffing
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, line{' ', a[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	truncated := (len(ma)+1)*(len(mb)+1) > maxDiffCells
+	if truncated {
+		if len(ma) > 0 {
+			lines = append(lines, line{'-', ma[0]})
+		}
+		if len(mb) > 0 {
+			lines = append(lines, line{'+', mb[0]})
+		}
+	} else {
+		// longest common subsequence of the lines, from the end
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				lines = append(lines, line{' ', ma[i]})
+				i++
+				j++
+			case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+				lines = append(lines, line{'-', ma[i]})
+				i++
+			default:
+				lines = append(lines, line{'+', mb[j]})
+				j++
+			}
+		}
+		for _, text := range a[len(a)-suffix:] {
+			lines = append(lines, line{' ', text})
+		}
+	}
+
+	// only keep the context around the changes
+	keep := make([]bool, len(lines))
+	for k, l := range lines {
+		if l.op != ' ' {
+			for c := max(0, k-diffContext); c <= min(len(lines)-1, k+diffContext); c++ {
+				keep[c] = true
+			}
+		}
+	}
+
+	var out strings.Builder
+	skipped := false
+	for k, l := range lines {
+		if !keep[k] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			out.WriteString("  ...\n")
+			skipped = false
+		}
+		text, newline := strings.CutSuffix(l.text, "\n")
+		if l.op != ' ' {
+			text = quoteIfNeeded(text)
+		}
+		fmt.Fprintf(&out, "%c %s\n", l.op, text)
+		if l.op != ' ' && !newline {
+			out.WriteString("\\ no newline at end\n")
+		}
+	}
+	if truncated {
+		out.WriteString("  ... (too long to diff, only the first difference is shown)\n")
+	} else if skipped {
+		out.WriteString("  ...\n")
+	}
+	return out.String()
+}
+
+// Lines of s, with their newlines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func quoteIfNeeded(s string) string {
+	for _, r := range s {
+		if r < ' ' && r != '\t' || r == 0x7f {
+			return fmt.Sprintf("%q", s)
+		}
+	}
+	if s == "" || strings.TrimSpace(s) != s {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
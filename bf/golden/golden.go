@@ -0,0 +1,287 @@
+// Package golden runs brainfuck programs against golden files. For a program
+// foo.bf the cases come from either
+//
+//   - foo.in and foo.out, the input and the expected output of a single case
+//     (foo.in is optional), or
+//   - foo.cases, with any number of named cases in sections which start with a
+//     `-- name.in --` or `-- name.out --` line (like a txtar archive)
+//
+// In a .cases file the final newline of an output section is not significant,
+// so that outputs which don't end in a newline can be written down. Inputs are
+// fed to the program as they are, final newline included. Only the last
+// section of the file can hold an input without one.
+package golden
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+)
+
+const (
+	inputExt  = ".in"
+	outputExt = ".out"
+	casesExt  = ".cases"
+)
+
+// A single run of a program
+type Case struct {
+	Name  string
+	Input []byte
+	Want  []byte
+}
+
+// Cases of a program
+type Suite struct {
+	// Path of the .bf file
+	Program string
+	Cases   []Case
+	// Path of the .cases file, if the cases come from one
+	casesFile string
+	sections  []section
+}
+
+// Find the .bf files matched by the patterns. A pattern is a .bf file, a
+// directory, or a directory followed by /... for all the directories under it.
+func Find(patterns []string) ([]string, error) {
+	var programs []string
+	for _, pattern := range patterns {
+		root, recursive := strings.CutSuffix(pattern, "...")
+		if recursive {
+			root = filepath.Clean(root)
+		}
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			programs = append(programs, root)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path != root && !recursive {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && filepath.Ext(path) == ".bf" {
+				programs = append(programs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(programs)
+	return slices.Compact(programs), nil
+}
+
+// Load the cases of a program. A program with a foo.in but no foo.out has a
+// case which expects no output, so that Update can write its first foo.out.
+// With update, so does a program without any golden files. Otherwise such a
+// program has no cases.
+func Load(program string, update bool) (*Suite, error) {
+	suite := &Suite{Program: program}
+	base := strings.TrimSuffix(program, filepath.Ext(program))
+
+	cases_file := base + casesExt
+	data, err := os.ReadFile(cases_file)
+	if err == nil {
+		suite.casesFile = cases_file
+		suite.sections = parseSections(data)
+		suite.Cases, err = casesFromSections(suite.sections)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cases_file, err)
+		}
+		return suite, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	want, err := os.ReadFile(base + outputExt)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	has_output := err == nil
+	input, err := os.ReadFile(base + inputExt)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	has_input := err == nil
+	if !has_output && !has_input && !update {
+		return suite, nil
+	}
+	suite.Cases = []Case{{Name: filepath.Base(base), Input: input, Want: want}}
+	return suite, nil
+}
+
+// Outcome of a case
+type Result struct {
+	Case     Case
+	Got      []byte
+	Err      error
+	Duration time.Duration
+}
+
+func (r Result) Passed() bool {
+	return r.Err == nil && bytes.Equal(r.Got, r.Case.Want)
+}
+
+// Run all the cases of the suite. A case fails if the program doesn't finish
+// within the timeout (0 for none), returns an error or its output doesn't
+// match.
+func (s *Suite) Run(ctx context.Context, options bf.Options, timeout time.Duration) ([]Result, error) {
+	source, err := os.ReadFile(s.Program)
+	if err != nil {
+		return nil, err
+	}
+	program := bf.Lex(bf.PreLex(string(source)))
+
+	var results []Result
+	for _, c := range s.Cases {
+		results = append(results, s.run(ctx, program, c, options, timeout))
+	}
+	return results, nil
+}
+
+func (s *Suite) run(ctx context.Context, program []bf.Command, c Case, options bf.Options, timeout time.Duration) Result {
+	result := Result{Case: c}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var out strings.Builder
+	interpreter := bf.NewInterpreter(program, bytes.NewReader(c.Input), &out)
+	start := time.Now()
+	result.Err = interpreter.SetOptions(options)
+	if result.Err == nil {
+		result.Err = interpreter.RunContext(ctx)
+	}
+	if result.Err == nil && ctx.Err() != nil {
+		result.Err = fmt.Errorf("did not finish: %w", ctx.Err())
+	}
+	result.Duration = time.Since(start)
+
+	result.Got = []byte(out.String())
+	if s.casesFile != "" {
+		result.Got = trimNewline(result.Got)
+	}
+	return result
+}
+
+// Write the outputs of the results as the new golden files. The cases which
+// didn't finish keep their golden files.
+func (s *Suite) Update(results []Result) error {
+	if s.casesFile == "" {
+		if len(results) == 0 || results[0].Err != nil {
+			return nil
+		}
+		base := strings.TrimSuffix(s.Program, filepath.Ext(s.Program))
+		return os.WriteFile(base+outputExt, results[0].Got, 0644)
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		name := result.Case.Name + outputExt
+		i := slices.IndexFunc(s.sections, func(sec section) bool { return sec.name == name })
+		if i < 0 {
+			// An input without a final newline has to stay the last section
+			i = len(s.sections)
+			if i > 0 && isUnterminatedInput(s.sections[i-1]) {
+				i--
+			}
+			s.sections = slices.Insert(s.sections, i, section{name: name})
+		}
+		s.sections[i].data = result.Got
+	}
+	return os.WriteFile(s.casesFile, formatSections(s.sections), 0644)
+}
+
+func trimNewline(data []byte) []byte {
+	return bytes.TrimSuffix(data, []byte("\n"))
+}
+
+// Section of a .cases file
+type section struct {
+	name string
+	data []byte
+}
+
+// Split a .cases file into sections. Anything before the first section is a
+// comment and gets dropped.
+func parseSections(data []byte) []section {
+	var sections []section
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := strings.TrimSpace(string(line))
+		if name, ok := strings.CutPrefix(trimmed, "-- "); ok {
+			if name, ok := strings.CutSuffix(name, " --"); ok {
+				sections = append(sections, section{name: strings.TrimSpace(name)})
+				continue
+			}
+		}
+		if len(sections) > 0 {
+			sec := &sections[len(sections)-1]
+			sec.data = append(sec.data, line...)
+		}
+	}
+	return sections
+}
+
+// Format the sections as a .cases file. Outputs get back the final newline
+// trimmed off them, inputs are written as they are.
+func formatSections(sections []section) []byte {
+	var buf bytes.Buffer
+	for _, sec := range sections {
+		fmt.Fprintf(&buf, "-- %s --\n", sec.name)
+		buf.Write(sec.data)
+		if len(sec.data) > 0 && !isUnterminatedInput(sec) && !bytes.HasSuffix(sec.data, []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func isUnterminatedInput(sec section) bool {
+	return filepath.Ext(sec.name) == inputExt && len(sec.data) > 0 && !bytes.HasSuffix(sec.data, []byte("\n"))
+}
+
+// Cases of the sections, in the order they first appear in
+func casesFromSections(sections []section) ([]Case, error) {
+	var cases []Case
+	index := map[string]int{}
+	for _, sec := range sections {
+		name, ext := sec.name, filepath.Ext(sec.name)
+		if ext != inputExt && ext != outputExt {
+			return nil, fmt.Errorf("section %q is neither %s nor %s", name, inputExt, outputExt)
+		}
+		name = strings.TrimSuffix(name, ext)
+		i, ok := index[name]
+		if !ok {
+			i = len(cases)
+			index[name] = i
+			cases = append(cases, Case{Name: name})
+		}
+		if ext == inputExt {
+			cases[i].Input = sec.data
+		} else {
+			cases[i].Want = trimNewline(sec.data)
+		}
+	}
+	return cases, nil
+}
@@ -0,0 +1,225 @@
+package golden_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/bf/golden"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+// echo the input until EOF
+const echo = ",[.,]"
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		utils.AssertNoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		utils.AssertNoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func runSuite(t *testing.T, program string) (*golden.Suite, []golden.Result) {
+	suite, err := golden.Load(program, false)
+	utils.AssertNoError(t, err)
+	results, err := suite.Run(context.Background(), bf.DefaultOptions(), time.Second)
+	utils.AssertNoError(t, err)
+	return suite, results
+}
+
+func TestFind(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.bf":        echo,
+		"notes.txt":   "",
+		"sub/b.bf":    echo,
+		"sub/sub/c.b": echo,
+	})
+
+	programs, err := golden.Find([]string{dir})
+	utils.AssertNoError(t, err)
+	utils.AssertEqualArrays(t, programs, []string{filepath.Join(dir, "a.bf")})
+
+	programs, err = golden.Find([]string{dir + "/...", filepath.Join(dir, "a.bf")})
+	utils.AssertNoError(t, err)
+	utils.AssertEqualArrays(t, programs, []string{filepath.Join(dir, "a.bf"), filepath.Join(dir, "sub/b.bf")})
+}
+
+func TestSuite_InOut(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"echo.bf":  echo,
+		"echo.in":  "hello\n",
+		"echo.out": "hello\n",
+		"none.bf":  echo,
+	})
+
+	suite, results := runSuite(t, filepath.Join(dir, "echo.bf"))
+	utils.AssertEqual(t, len(suite.Cases), 1)
+	utils.AssertEqual(t, suite.Cases[0].Name, "echo")
+	utils.AssertEqual(t, results[0].Passed(), true)
+
+	suite, _ = runSuite(t, filepath.Join(dir, "none.bf"))
+	utils.AssertEqual(t, len(suite.Cases), 0)
+}
+
+func TestSuite_NewGoldenFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"echo.bf":  echo,
+		"echo.in":  "hello\n",
+		"hello.bf": "++++++++[>++++++++<-]>+.",
+	})
+
+	// A missing foo.out fails the case instead of skipping it
+	suite, results := runSuite(t, filepath.Join(dir, "echo.bf"))
+	utils.AssertEqual(t, len(results), 1)
+	utils.AssertEqual(t, results[0].Passed(), false)
+	utils.AssertNoError(t, suite.Update(results))
+
+	// Without foo.in a case only exists for an update
+	suite, err := golden.Load(filepath.Join(dir, "hello.bf"), true)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, len(suite.Cases), 1)
+	results, err = suite.Run(context.Background(), bf.DefaultOptions(), time.Second)
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, suite.Update(results))
+
+	for name, want := range map[string]string{"echo.out": "hello\n", "hello.out": "A"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		utils.AssertNoError(t, err)
+		utils.AssertEqual(t, string(data), want)
+	}
+	_, results = runSuite(t, filepath.Join(dir, "echo.bf"))
+	utils.AssertEqual(t, results[0].Passed(), true)
+}
+
+func TestSuite_Cases(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"echo.bf": echo,
+		"echo.cases": "comment\n" +
+			"-- one.in --\nabc\n-- one.out --\nabc\n" +
+			"-- two.in --\nxyz\n-- two.out --\nxy\n" +
+			"-- three.in --\nnew\n",
+	})
+
+	suite, results := runSuite(t, filepath.Join(dir, "echo.bf"))
+	utils.AssertEqual(t, len(results), 3)
+	utils.AssertEqual(t, results[0].Case.Name, "one")
+	utils.AssertEqual(t, results[0].Passed(), true)
+	utils.AssertEqual(t, results[1].Passed(), false)
+	utils.AssertEqual(t, results[2].Passed(), false)
+
+	utils.AssertNoError(t, suite.Update(results))
+	_, results = runSuite(t, filepath.Join(dir, "echo.bf"))
+	for _, result := range results {
+		utils.Assert(t, result.Passed(), result.Case.Name+" should pass after the update")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "echo.cases"))
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, string(data), ""+
+		"-- one.in --\nabc\n-- one.out --\nabc\n"+
+		"-- two.in --\nxyz\n-- two.out --\nxyz\n"+
+		"-- three.in --\nnew\n-- three.out --\nnew\n")
+}
+
+func TestSuite_CasesInputNewline(t *testing.T) {
+	// The input of the last section has no final newline
+	dir := writeFiles(t, map[string]string{
+		"echo.bf":    echo,
+		"echo.cases": "-- line.in --\nabc\n-- line.out --\nabc\n-- bare.in --\nabc",
+	})
+
+	suite, results := runSuite(t, filepath.Join(dir, "echo.bf"))
+	utils.AssertEqual(t, len(results), 2)
+	utils.AssertEqual(t, string(results[0].Case.Input), "abc\n")
+	utils.AssertEqual(t, string(results[1].Case.Input), "abc")
+	utils.AssertEqual(t, results[0].Passed(), true)
+	utils.AssertEqual(t, results[1].Passed(), false)
+
+	// The new output goes in front of the input, which keeps it as it is
+	utils.AssertNoError(t, suite.Update(results))
+	data, err := os.ReadFile(filepath.Join(dir, "echo.cases"))
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, string(data), "-- line.in --\nabc\n-- line.out --\nabc\n-- bare.out --\nabc\n-- bare.in --\nabc")
+	_, results = runSuite(t, filepath.Join(dir, "echo.bf"))
+	utils.AssertEqual(t, string(results[1].Case.Input), "abc")
+	utils.AssertEqual(t, results[1].Passed(), true)
+}
+
+func TestSuite_Timeout(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"loop.bf":  "+[]",
+		"loop.out": "",
+	})
+	suite, err := golden.Load(filepath.Join(dir, "loop.bf"), false)
+	utils.AssertNoError(t, err)
+	results, err := suite.Run(context.Background(), bf.DefaultOptions(), 10*time.Millisecond)
+	utils.AssertNoError(t, err)
+	utils.AssertError(t, results[0].Err)
+	utils.AssertEqual(t, results[0].Passed(), false)
+}
+
+func TestDiff(t *testing.T) {
+	utils.AssertEqual(t, golden.Diff("a\nb\nc\n", "a\nB\nc\n"), "  a\n- b\n+ B\n  c\n")
+	utils.AssertEqual(t, golden.Diff("a\n", "a"), "- a\n+ a\n\\ no newline at end\n")
+	utils.AssertEqual(t, golden.Diff("1\n2\n3\n4\n5\n6\n", "1\n2\n3\n4\n5\n6\n7\n"), "  ...\n  5\n  6\n+ 7\n")
+	utils.AssertEqual(t, golden.Diff("x \n", "x\n"), "- \"x \"\n+ x\n")
+	utils.AssertEqual(t, golden.Diff("a\nb\nc\n", "a\nc\n"), "  a\n- b\n  c\n")
+}
+
+func TestDiff_Large(t *testing.T) {
+	// Too many differing lines for the LCS table, so only the first
+	// difference gets shown
+	var want, got strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&want, "want %d\n", i)
+		fmt.Fprintf(&got, "got %d\n", i)
+	}
+	utils.AssertEqual(t, golden.Diff("same\n"+want.String(), "same\n"+got.String()),
+		"  same\n- want 0\n+ got 0\n  ... (too long to diff, only the first difference is shown)\n")
+
+	// Long, but only a line in the middle differs
+	lines := strings.Repeat("x\n", 5000)
+	utils.AssertEqual(t, golden.Diff(lines+"a\n"+lines, lines+"b\n"+lines), "  ...\n  x\n  x\n- a\n+ b\n  x\n  x\n  ...\n")
+}
+
+func TestWriteReports(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"echo.bf":    echo,
+		"echo.cases": "-- ok.in --\nabc\n-- ok.out --\nabc\n-- bad.in --\nabc\n-- bad.out --\nabd\n",
+	})
+	program := filepath.Join(dir, "echo.bf")
+	_, results := runSuite(t, program)
+	suites := []golden.SuiteResult{{Program: program, Results: results}}
+
+	var text bytes.Buffer
+	golden.WriteText(&text, suites, false)
+	utils.Assert(t, strings.Contains(text.String(), "--- FAIL: echo.bf/bad"), "bad should fail")
+	utils.Assert(t, !strings.Contains(text.String(), "echo.bf/ok"), "ok should not be listed")
+	utils.Assert(t, strings.Contains(text.String(), "- abd\n"), "the diff should be shown")
+	utils.Assert(t, strings.HasSuffix(text.String(), "\nFAIL\n"), "the run should fail")
+
+	var junit bytes.Buffer
+	utils.AssertNoError(t, golden.WriteJUnit(&junit, suites))
+	utils.Assert(t, strings.Contains(junit.String(), `<testsuites tests="2" failures="1" errors="0"`), junit.String())
+	utils.Assert(t, strings.Contains(junit.String(), `<failure message="output differs">`), junit.String())
+}
+
+// The golden files of the example programs
+func TestPrograms(t *testing.T) {
+	programs, err := golden.Find([]string{"../programs/..."})
+	utils.AssertNoError(t, err)
+	for _, program := range programs {
+		_, results := runSuite(t, program)
+		for _, result := range results {
+			utils.Assert(t, result.Passed(), program+"/"+result.Case.Name+" should pass")
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package golden
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Results of all the cases of a program
+type SuiteResult struct {
+	Program string
+	Results []Result
+	// Error loading or running the suite, in which case there are no results
+	Err      error
+	Duration time.Duration
+}
+
+func (s SuiteResult) Passed() bool {
+	if s.Err != nil {
+		return false
+	}
+	for _, result := range s.Results {
+		if !result.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// Name of a case, the way go test names subtests
+func (s SuiteResult) testName(result Result) string {
+	return filepath.Base(s.Program) + "/" + result.Case.Name
+}
+
+// Why the case failed
+func failure(result Result) string {
+	if result.Err != nil {
+		return result.Err.Error()
+	}
+	return "output differs (-want +got):\n" + Diff(string(result.Case.Want), string(result.Got))
+}
+
+// Write the results the way go test does. Passing cases are only listed when
+// verbose.
+func WriteText(w io.Writer, suites []SuiteResult, verbose bool) {
+	passed := true
+	for _, suite := range suites {
+		for _, result := range suite.Results {
+			name := suite.testName(result)
+			if verbose {
+				fmt.Fprintf(w, "=== RUN   %s\n", name)
+			}
+			if result.Passed() {
+				if verbose {
+					fmt.Fprintf(w, "--- PASS: %s (%.2fs)\n", name, result.Duration.Seconds())
+				}
+				continue
+			}
+			fmt.Fprintf(w, "--- FAIL: %s (%.2fs)\n", name, result.Duration.Seconds())
+			for _, line := range strings.Split(strings.TrimSuffix(failure(result), "\n"), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+
+		switch {
+		case suite.Err != nil:
+			passed = false
+			fmt.Fprintf(w, "FAIL\t%s\t%s\n", suite.Program, suite.Err)
+		case len(suite.Results) == 0:
+			fmt.Fprintf(w, "?   \t%s\t[no golden files]\n", suite.Program)
+		case suite.Passed():
+			fmt.Fprintf(w, "ok  \t%s\t%.3fs\n", suite.Program, suite.Duration.Seconds())
+		default:
+			passed = false
+			fmt.Fprintf(w, "FAIL\t%s\t%.3fs\n", suite.Program, suite.Duration.Seconds())
+		}
+	}
+	if passed {
+		fmt.Fprintln(w, "PASS")
+	} else {
+		fmt.Fprintln(w, "FAIL")
+	}
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// Write the results as JUnit XML, with a testsuite per program. A case whose
+// output differs is a failure, a case which didn't finish is an error.
+func WriteJUnit(w io.Writer, suites []SuiteResult) error {
+	report := junitTestSuites{}
+	var total time.Duration
+	for _, suite := range suites {
+		js := junitTestSuite{Name: suite.Program, Time: junitTime(suite.Duration)}
+		if suite.Err != nil {
+			js.Errors++
+			js.Cases = append(js.Cases, junitTestCase{
+				Name:      filepath.Base(suite.Program),
+				Classname: suite.Program,
+				Time:      junitTime(0),
+				Error:     &junitProblem{Message: suite.Err.Error()},
+			})
+		}
+		for _, result := range suite.Results {
+			jc := junitTestCase{
+				Name:      result.Case.Name,
+				Classname: suite.Program,
+				Time:      junitTime(result.Duration),
+			}
+			switch {
+			case result.Err != nil:
+				js.Errors++
+				jc.Error = &junitProblem{Message: result.Err.Error()}
+			case !result.Passed():
+				js.Failures++
+				jc.Failure = &junitProblem{Message: "output differs", Body: failure(result)}
+			}
+			js.Cases = append(js.Cases, jc)
+		}
+		js.Tests = len(js.Cases)
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Errors += js.Errors
+		total += suite.Duration
+		report.Suites = append(report.Suites, js)
+	}
+	report.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -4,33 +4,113 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
+	"sync"
 )
 
-// comptime override for debug flag
-// set with `-ldflags="-X 'github.com/MarcinKonowalczyk/runbf/bf.debug=true'"`
-var debug string
-
 type Interpreter struct {
 	Program     []Command
 	program_ptr uint32
-	mem         []uint8
+	mem         []uint32
 	mem_ptr     uint32
 	Input       io.Reader
 	Output      io.StringWriter
-	debug       bool
+
+	log     *slog.Logger
+	options Options
+	// mask of the bits of a cell
+	cell_mask uint32
+	// index of the matching bracket of each bracket, for the fast engine
+	jumps []uint32
+	// number of executions of each instruction, when profiling
+	counts []uint64
+
+	// counters, owned by the goroutine running the program
+	instructions    uint64
+	bytes_read      uint64
+	bytes_written   uint64
+	tape_high_water uint32
+	// instructions when the counters were last published
+	published uint64
+
+	// snapshot of the counters, safe to read from other goroutines
+	stats_mu sync.Mutex
+	stats    Stats
+}
+
+// Execution counters of an interpreter
+type Stats struct {
+	// Number of instructions executed so far
+	Instructions uint64
+	// Index of the next instruction to execute
+	ProgramCounter uint32
+	// Highest index of a memory cell the program has moved to
+	TapeHighWater uint32
+	// Number of bytes read from the input
+	BytesRead uint64
+	// Number of bytes written to the output
+	BytesWritten uint64
 }
 
-func NewInterpreter(program []Command, input io.Reader, output io.StringWriter, debug bool) *Interpreter {
-	return &Interpreter{
+// How often (in instructions) the counters get published to Stats
+const statsInterval = 1 << 12
+
+func NewInterpreter(program []Command, input io.Reader, output io.StringWriter) *Interpreter {
+	i := &Interpreter{
 		Program:     program,
 		program_ptr: 0,
-		mem:         make([]uint8, 30_000),
 		mem_ptr:     0,
 		Input:       input,
 		Output:      output,
-		debug:       debug,
+		log:         slog.New(slog.DiscardHandler),
+	}
+	// the defaults are always valid
+	i.SetOptions(DefaultOptions())
+	return i
+}
+
+// Change the settings of the interpreter. This clears the memory, so it should
+// be done before running the program.
+func (i *Interpreter) SetOptions(options Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	i.options = options
+	i.mem = make([]uint32, options.TapeSize)
+	i.cell_mask = uint32(1<<options.CellWidth - 1)
+	i.jumps = nil
+	return nil
+}
+
+func (i *Interpreter) Options() Options {
+	return i.options
+}
+
+// Log to this logger. By default the interpreter logs nothing.
+func (i *Interpreter) SetLogger(logger *slog.Logger) {
+	i.log = logger
+}
+
+// Find the matching bracket of every bracket in the program. Unmatched brackets
+// jump to themselves, which is what the basic engine ends up doing too.
+func matchBrackets(program []Command) []uint32 {
+	jumps := make([]uint32, len(program))
+	var stack []uint32
+	for j, c := range program {
+		jumps[j] = uint32(j)
+		switch c {
+		case LoopStart:
+			stack = append(stack, uint32(j))
+		case LoopEnd:
+			if len(stack) > 0 {
+				start := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				jumps[start] = uint32(j)
+				jumps[j] = start
+			}
+		}
 	}
+	return jumps
 }
 
 func (i *Interpreter) Reset() {
@@ -39,6 +119,35 @@ func (i *Interpreter) Reset() {
 	for j := range i.mem {
 		i.mem[j] = 0
 	}
+	i.instructions = 0
+	if i.counts != nil {
+		i.counts = make([]uint64, len(i.Program))
+	}
+	i.bytes_read = 0
+	i.bytes_written = 0
+	i.tape_high_water = 0
+	i.publishStats()
+}
+
+// Snapshot of the execution counters. Safe to call while the program is
+// running, in which case the counters may lag slightly behind.
+func (i *Interpreter) Stats() Stats {
+	i.stats_mu.Lock()
+	defer i.stats_mu.Unlock()
+	return i.stats
+}
+
+func (i *Interpreter) publishStats() {
+	i.published = i.instructions
+	i.stats_mu.Lock()
+	defer i.stats_mu.Unlock()
+	i.stats = Stats{
+		Instructions:   i.instructions,
+		ProgramCounter: i.program_ptr,
+		TapeHighWater:  i.tape_high_water,
+		BytesRead:      i.bytes_read,
+		BytesWritten:   i.bytes_written,
+	}
 }
 
 func (i *Interpreter) MemoryLength() int {
@@ -56,7 +165,7 @@ func wrap_index(i int32, N int32) int32 {
 }
 
 // Index the memory
-func (i *Interpreter) At(j int32) uint8 {
+func (i *Interpreter) At(j int32) uint32 {
 	return i.mem[wrap_index(j, int32(i.MemoryLength()))]
 }
 
@@ -66,47 +175,87 @@ func (i *Interpreter) At(j int32) uint8 {
 // 	// return i.mem[wrap_index(start, N):wrap_index(end, N)]
 // }
 
-// Write a debug message to stderr if debug is enabled
-func logf(format string, args ...interface{}) {
-	if debug != "" {
-		fmt.Fprintf(os.Stderr, format, args...)
+// Run the program in a loop until it finishes, the context is cancelled or it
+// exceeds the step limit
+func (i *Interpreter) RunContext(ctx context.Context) (err error) {
+	i.log.Debug("running program",
+		"commands", len(i.Program),
+		"cell_width", i.options.CellWidth,
+		"tape_size", i.options.TapeSize,
+		"eof_mode", i.options.EOFMode,
+		"engine", i.options.Engine,
+		"max_steps", i.options.MaxSteps,
+	)
+	defer func() {
+		i.publishStats()
+		stats := i.Stats()
+		args := []any{
+			"instructions", stats.Instructions,
+			"program_counter", stats.ProgramCounter,
+			"bytes_read", stats.BytesRead,
+			"bytes_written", stats.BytesWritten,
+		}
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		i.log.Debug("program stopped", args...)
+	}()
+	switch i.options.Engine {
+	case EngineOptimized, EngineCompiled:
+		// They can only start from the start of the program. A program which
+		// stopped part of the way carries on with the fast engine.
+		if i.program_ptr == 0 && i.options.Engine == EngineOptimized {
+			return i.runOptimized(ctx)
+		}
+		if i.program_ptr == 0 {
+			return i.runCompiled(ctx)
+		}
+		i.jumps = matchBrackets(i.Program)
+	case EngineFast:
+		i.jumps = matchBrackets(i.Program)
 	}
-}
-
-// Run the program in a loop until it finishes or an error occurs
-func (i *Interpreter) RunContext(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 		}
+		// checked first, so that an empty (or finished) program does nothing
+		if i.program_ptr >= uint32(len(i.Program)) {
+			return nil
+		}
+		if i.options.MaxSteps != 0 && i.instructions >= i.options.MaxSteps {
+			return ErrStepLimit
+		}
+		if i.counts != nil {
+			i.counts[i.program_ptr]++
+		}
 		c := i.Program[i.program_ptr]
 		switch c {
 		case Increment:
-			i.mem[i.mem_ptr]++
+			i.mem[i.mem_ptr] = (i.mem[i.mem_ptr] + 1) & i.cell_mask
 		case Decrement:
-			i.mem[i.mem_ptr]--
+			i.mem[i.mem_ptr] = (i.mem[i.mem_ptr] - 1) & i.cell_mask
 		case Right:
 			i.mem_ptr++
 			if i.mem_ptr >= uint32(len(i.mem)) {
 				i.mem_ptr = 0
 			}
+			if i.mem_ptr > i.tape_high_water {
+				i.tape_high_water = i.mem_ptr
+			}
 		case Left:
 			if i.mem_ptr == 0 {
 				i.mem_ptr = uint32(len(i.mem) - 1)
+				i.tape_high_water = i.mem_ptr
 			} else {
 				i.mem_ptr--
 			}
 		case Output:
 			if i.Output != nil {
-				to_write := i.mem[i.mem_ptr]
-				if to_write == '\n' {
-					// Patch for Windows and, from some reason, docker
-					i.Output.WriteString("\r\n")
-				} else {
-					i.Output.WriteString(string(to_write))
-				}
+				// NOTE: newline translation is the job of the terminal (if any)
+				i.Output.WriteString(string(rune(i.mem[i.mem_ptr])))
+				i.bytes_written++
 			}
 		case Input:
 			if i.Input != nil {
@@ -114,18 +263,29 @@ func (i *Interpreter) RunContext(ctx context.Context) {
 				buff := make([]byte, 1)
 				_, err := i.Input.Read(buff)
 				if err != nil {
-					if err == io.EOF {
-						logf("EOF")
-						return
+					if err != io.EOF {
+						return fmt.Errorf("reading input: %w", err)
+					}
+					i.log.Debug("end of input", "eof_mode", i.options.EOFMode)
+					switch i.options.EOFMode {
+					case EOFStop:
+						return nil
+					case EOFZero:
+						i.mem[i.mem_ptr] = 0
+					case EOFMax:
+						i.mem[i.mem_ptr] = i.cell_mask
+					case EOFUnchanged:
 					}
-					logf("Error reading input: %v", err)
-					panic(err)
+				} else {
+					i.mem[i.mem_ptr] = uint32(buff[0])
+					i.bytes_read++
 				}
-				i.mem[i.mem_ptr] = buff[0]
 			}
 		case LoopStart:
 			v := i.mem[i.mem_ptr]
-			if v == 0 {
+			if v == 0 && i.jumps != nil {
+				i.program_ptr = i.jumps[i.program_ptr]
+			} else if v == 0 {
 				// Find the matching LoopEnd
 				depth := 1
 				for j := i.program_ptr + 1; j < uint32(len(i.Program)); j++ {
@@ -144,7 +304,9 @@ func (i *Interpreter) RunContext(ctx context.Context) {
 			}
 		case LoopEnd:
 			v := i.mem[i.mem_ptr]
-			if v != 0 {
+			if v != 0 && i.jumps != nil {
+				i.program_ptr = i.jumps[i.program_ptr]
+			} else if v != 0 {
 				// Find the matching LoopStart
 				depth := 1
 				for j := i.program_ptr - 1; j > 0; j-- {
@@ -167,12 +329,13 @@ func (i *Interpreter) RunContext(ctx context.Context) {
 			panic("Unknown command")
 		}
 		i.program_ptr++
-		if i.program_ptr >= uint32(len(i.Program)) {
-			break
+		i.instructions++
+		if i.instructions%statsInterval == 0 {
+			i.publishStats()
 		}
 	}
 }
 
-func (i *Interpreter) Run() {
-	i.RunContext(context.Background())
+func (i *Interpreter) Run() error {
+	return i.RunContext(context.Background())
 }
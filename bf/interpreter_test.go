@@ -1,6 +1,9 @@
 package bf_test
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/MarcinKonowalczyk/runbf/bf"
@@ -9,19 +12,19 @@ import (
 
 func TestInterpreter_OutputEmptyInterpreter(t *testing.T) {
 	program := []bf.Command{bf.Output}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	interpreter.Run()
 }
 
 func TestInterpreter_InputEmptyInterpreter(t *testing.T) {
 	program := []bf.Command{bf.Input}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	interpreter.Run()
 }
 
 func TestInterpreter_Increment(t *testing.T) {
 	program := []bf.Command{bf.Increment}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	utils.AssertEqual(t, interpreter.At(0), 0)
 	interpreter.Run()
 	utils.AssertEqual(t, interpreter.At(0), 1)
@@ -29,7 +32,7 @@ func TestInterpreter_Increment(t *testing.T) {
 
 func TestInterpreter_Decrement(t *testing.T) {
 	program := []bf.Command{bf.Decrement}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	utils.AssertEqual(t, interpreter.At(0), 0)
 	interpreter.Run()
 	utils.AssertEqual(t, interpreter.At(0), 255)
@@ -37,7 +40,7 @@ func TestInterpreter_Decrement(t *testing.T) {
 
 func TestInterpreter_MoveRight(t *testing.T) {
 	program := []bf.Command{bf.Right, bf.Increment}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	utils.AssertEqual(t, interpreter.At(0), 0)
 	utils.AssertEqual(t, interpreter.At(1), 0)
 	interpreter.Run()
@@ -47,7 +50,7 @@ func TestInterpreter_MoveRight(t *testing.T) {
 
 func TestInterpreter_MoveLeft(t *testing.T) {
 	program := []bf.Command{bf.Left, bf.Increment}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	utils.AssertEqual(t, interpreter.At(0), 0)
 	utils.AssertEqual(t, interpreter.At(-1), 0)
 	interpreter.Run()
@@ -68,10 +71,136 @@ func TestInterpreter_Loop(t *testing.T) {
 		bf.Left,
 		bf.LoopEnd,
 	}
-	interpreter := bf.NewInterpreter(program, nil, nil, false)
+	interpreter := bf.NewInterpreter(program, nil, nil)
 	utils.AssertEqual(t, interpreter.At(0), 0)
 	utils.AssertEqual(t, interpreter.At(1), 0)
 	interpreter.Run()
 	utils.AssertEqual(t, interpreter.At(0), 0)
 	utils.AssertEqual(t, interpreter.At(1), 3)
 }
+
+func TestInterpreter_CancelledContext(t *testing.T) {
+	// +[] would loop forever
+	program := []bf.Command{bf.Increment, bf.LoopStart, bf.LoopEnd}
+	interpreter := bf.NewInterpreter(program, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	interpreter.RunContext(ctx)
+	utils.AssertEqual(t, interpreter.At(0), 0)
+}
+
+func TestInterpreter_Stats(t *testing.T) {
+	// +>+<[-]
+	program := bf.Lex("+>+<[-]")
+	interpreter := bf.NewInterpreter(program, nil, nil)
+	interpreter.Run()
+	stats := interpreter.Stats()
+	utils.AssertEqual(t, stats.Instructions, 7)
+	utils.AssertEqual(t, stats.ProgramCounter, 7)
+	utils.AssertEqual(t, stats.TapeHighWater, 1)
+	utils.AssertEqual(t, stats.BytesRead, 0)
+	utils.AssertEqual(t, stats.BytesWritten, 0)
+}
+
+func TestInterpreter_CellWidth(t *testing.T) {
+	program := []bf.Command{bf.Decrement}
+	interpreter := bf.NewInterpreter(program, nil, nil)
+	options := bf.DefaultOptions()
+	options.CellWidth = 16
+	utils.AssertNoError(t, interpreter.SetOptions(options))
+	interpreter.Run()
+	utils.AssertEqual(t, interpreter.At(0), 65535)
+}
+
+func TestInterpreter_TapeSize(t *testing.T) {
+	program := []bf.Command{bf.Left, bf.Increment}
+	interpreter := bf.NewInterpreter(program, nil, nil)
+	options := bf.DefaultOptions()
+	options.TapeSize = 10
+	utils.AssertNoError(t, interpreter.SetOptions(options))
+	interpreter.Run()
+	utils.AssertEqual(t, interpreter.MemoryLength(), 10)
+	utils.AssertEqual(t, interpreter.At(9), 1)
+}
+
+func TestInterpreter_EOFModes(t *testing.T) {
+	// +,. reads past the end of the input
+	program := []bf.Command{bf.Increment, bf.Input, bf.Increment}
+	for mode, expected := range map[bf.EOFMode]uint32{
+		bf.EOFStop:      1,
+		bf.EOFZero:      1,
+		bf.EOFMax:       0,
+		bf.EOFUnchanged: 2,
+	} {
+		interpreter := bf.NewInterpreter(program, strings.NewReader(""), nil)
+		options := bf.DefaultOptions()
+		options.EOFMode = mode
+		utils.AssertNoError(t, interpreter.SetOptions(options))
+		interpreter.Run()
+		utils.AssertEqual(t, interpreter.At(0), expected)
+	}
+}
+
+func TestInterpreter_FastEngine(t *testing.T) {
+	source := "++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++."
+	for _, engine := range bf.Engines {
+		var out strings.Builder
+		interpreter := bf.NewInterpreter(bf.Lex(source), nil, &out)
+		options := bf.DefaultOptions()
+		options.Engine = engine
+		utils.AssertNoError(t, interpreter.SetOptions(options))
+		utils.AssertNoError(t, interpreter.Run())
+		utils.AssertEqual(t, out.String(), "Hello World!\n")
+	}
+}
+
+func TestInterpreter_StepLimit(t *testing.T) {
+	// +[] never terminates
+	program := []bf.Command{bf.Increment, bf.LoopStart, bf.LoopEnd}
+	interpreter := bf.NewInterpreter(program, nil, nil)
+	options := bf.DefaultOptions()
+	options.MaxSteps = 100
+	utils.AssertNoError(t, interpreter.SetOptions(options))
+	err := interpreter.Run()
+	utils.Assert(t, errors.Is(err, bf.ErrStepLimit), "expected the step limit to be hit")
+	utils.AssertEqual(t, interpreter.Stats().Instructions, 100)
+}
+
+func TestOptions_Validate(t *testing.T) {
+	utils.AssertNoError(t, bf.DefaultOptions().Validate())
+	options := bf.DefaultOptions()
+	options.CellWidth = 12
+	utils.AssertError(t, options.Validate())
+	options = bf.DefaultOptions()
+	options.EOFMode = "explode"
+	utils.AssertError(t, options.Validate())
+}
+
+func TestInterpreter_EmptyProgram(t *testing.T) {
+	interpreter := bf.NewInterpreter(bf.Lex(""), nil, nil)
+	utils.AssertNoError(t, interpreter.Run())
+	utils.AssertEqual(t, interpreter.Stats().Instructions, 0)
+}
+
+func TestInterpreter_RunFinished(t *testing.T) {
+	interpreter := bf.NewInterpreter(bf.Lex("+"), nil, nil)
+	utils.AssertNoError(t, interpreter.Run())
+	// nothing left to run
+	utils.AssertNoError(t, interpreter.Run())
+	utils.AssertEqual(t, interpreter.At(0), 1)
+}
+
+func TestInterpreter_ClearLoop(t *testing.T) {
+	// Goes around the loop 2^32-1 times, which the optimized engines do at once
+	program := bf.Lex("-[-]")
+	for _, engine := range []bf.Engine{bf.EngineOptimized, bf.EngineCompiled} {
+		interpreter := bf.NewInterpreter(program, nil, nil)
+		options := bf.DefaultOptions()
+		options.CellWidth = 32
+		options.Engine = engine
+		utils.AssertNoError(t, interpreter.SetOptions(options))
+		utils.AssertNoError(t, interpreter.Run())
+		utils.AssertEqual(t, interpreter.At(0), 0)
+		utils.AssertEqual(t, interpreter.Stats().Instructions, 2+2*(1<<32-1))
+	}
+}
@@ -1,5 +1,7 @@
 package bf
 
+import "fmt"
+
 func PreLex(input string) string {
 	var result []rune
 	for _, c := range input {
@@ -101,3 +103,32 @@ func Lex(input string) []Command {
 	lexer := NewLexer(input)
 	return lexer.Lex()
 }
+
+// Position of a command in the source, counting from 1. The column counts
+// runes, not bytes.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Positions of the commands of Lex(PreLex(source)) in the source
+func Positions(source string) []Position {
+	var positions []Position
+	pos := Position{Line: 1, Column: 1}
+	for _, c := range source {
+		if parse(c) != Ignore {
+			positions = append(positions, pos)
+		}
+		if c == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	return positions
+}
@@ -29,3 +29,10 @@ func TestLex(t *testing.T) {
 	result := bf.Lex(input)
 	utils.AssertEqualArrays(t, expected, result)
 }
+
+func TestPositions(t *testing.T) {
+	input := "+ a\n[-]\n\n  ."
+	expected := []bf.Position{{1, 1}, {2, 1}, {2, 2}, {2, 3}, {4, 3}}
+	utils.AssertEqualArrays(t, bf.Positions(input), expected)
+	utils.AssertEqual(t, len(bf.Positions(input)), len(bf.Lex(bf.PreLex(input))))
+}
@@ -6,16 +6,19 @@ import (
 	"os"
 )
 
-func RunContext(ctx context.Context, source string, input io.Reader, output io.StringWriter) {
+func RunContext(ctx context.Context, source string, input io.Reader, output io.StringWriter, options Options) error {
 	source = PreLex(source)
 	lexer := NewLexer(source)
 
 	commands := lexer.Lex()
 
-	interpreter := NewInterpreter(commands, input, output, false)
-	interpreter.RunContext(ctx)
+	interpreter := NewInterpreter(commands, input, output)
+	if err := interpreter.SetOptions(options); err != nil {
+		return err
+	}
+	return interpreter.RunContext(ctx)
 }
 
-func Run(source string) {
-	RunContext(context.Background(), source, os.Stdin, os.Stdout)
+func Run(source string) error {
+	return RunContext(context.Background(), source, os.Stdin, os.Stdout, DefaultOptions())
 }
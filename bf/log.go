@@ -0,0 +1,33 @@
+package bf
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Environment variable with the log level of the command line interpreters
+// (debug, info, warn or error)
+const LogLevelEnv = "BF_LOG_LEVEL"
+
+// Logger which writes structured logs at the given level or above. With json
+// each record is a json object on its own line, which is what the shim expects
+// from the interpreter process.
+func NewLogger(w io.Writer, level slog.Level, json bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Log level from the environment, or info if it's not set
+func LogLevelFromEnv() (slog.Level, error) {
+	level := slog.LevelInfo
+	if value, ok := os.LookupEnv(LogLevelEnv); ok {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return level, err
+		}
+	}
+	return level, nil
+}
@@ -0,0 +1,287 @@
+package bf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Instruction of the optimized and compiled engines. It stands for n commands
+// of the program from pc on, and does exactly what they do, down to the
+// counters and the profile.
+type op struct {
+	// The command which the op repeats. For a clear, the `+` or `-` in the loop.
+	cmd Command
+	// `[-]` or `[+]`, which runs until the cell is 0
+	clear bool
+	// Index of the first command in the program
+	pc uint32
+	// Number of commands
+	n uint32
+	// Index of the op of the matching bracket. An unmatched bracket matches
+	// itself, so that it carries on with the next op either way, like in the
+	// fast engine.
+	jump int
+}
+
+// Returned by an op to stop the program without an error
+var (
+	errEndOfInput = errors.New("end of input")
+	errCancelled  = errors.New("cancelled")
+)
+
+// Translate the program to ops. Runs of the same command (other than input
+// and output) become a single op, and so do the loops which clear a cell.
+func optimize(program []Command) []op {
+	var ops []op
+	var stack []int
+	for pc := 0; pc < len(program); {
+		c := program[pc]
+		switch c {
+		case Increment, Decrement, Left, Right, Ignore:
+			n := 1
+			for pc+n < len(program) && program[pc+n] == c {
+				n++
+			}
+			ops = append(ops, op{cmd: c, pc: uint32(pc), n: uint32(n)})
+			pc += n
+		case LoopStart:
+			if pc+2 < len(program) && (program[pc+1] == Increment || program[pc+1] == Decrement) && program[pc+2] == LoopEnd {
+				ops = append(ops, op{cmd: program[pc+1], clear: true, pc: uint32(pc), n: 3})
+				pc += 3
+				continue
+			}
+			stack = append(stack, len(ops))
+			ops = append(ops, op{cmd: c, pc: uint32(pc), n: 1, jump: len(ops)})
+			pc++
+		case LoopEnd:
+			jump := len(ops)
+			if len(stack) > 0 {
+				jump = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				ops[jump].jump = len(ops)
+			}
+			ops = append(ops, op{cmd: c, pc: uint32(pc), n: 1, jump: jump})
+			pc++
+		case Output, Input:
+			ops = append(ops, op{cmd: c, pc: uint32(pc), n: 1})
+			pc++
+		default:
+			panic("Unknown command")
+		}
+	}
+	return ops
+}
+
+// Number of instructions the program can still execute before the step limit
+func (i *Interpreter) budget() uint64 {
+	if i.options.MaxSteps == 0 {
+		return math.MaxUint64
+	}
+	if i.instructions >= i.options.MaxSteps {
+		return 0
+	}
+	return i.options.MaxSteps - i.instructions
+}
+
+// Publish the counters every statsInterval instructions or so. Ops execute
+// many instructions at once, so they don't hit the multiples of it.
+func (i *Interpreter) publishStatsDue() {
+	if i.instructions-i.published >= statsInterval {
+		i.publishStats()
+	}
+}
+
+// Execute a bracket op, and return whether it jumps to its matching bracket
+func (i *Interpreter) bracket(o *op) (bool, error) {
+	if i.budget() == 0 {
+		i.program_ptr = o.pc
+		return false, ErrStepLimit
+	}
+	if i.counts != nil {
+		i.counts[o.pc]++
+	}
+	i.instructions++
+	v := i.mem[i.mem_ptr]
+	if o.cmd == LoopStart {
+		return v == 0, nil
+	}
+	return v != 0, nil
+}
+
+// Execute an op which is not a bracket. When the step limit is reached in the
+// middle of it, the commands up to the limit get executed.
+func (i *Interpreter) exec(o *op) error {
+	budget := i.budget()
+	if budget == 0 {
+		i.program_ptr = o.pc
+		return ErrStepLimit
+	}
+	if o.clear {
+		return i.clear(o, budget)
+	}
+
+	n := min(uint64(o.n), budget)
+	if i.counts != nil {
+		for j := range uint32(n) {
+			i.counts[o.pc+j]++
+		}
+	}
+	switch o.cmd {
+	case Increment:
+		i.mem[i.mem_ptr] = (i.mem[i.mem_ptr] + uint32(n)) & i.cell_mask
+	case Decrement:
+		i.mem[i.mem_ptr] = (i.mem[i.mem_ptr] - uint32(n)) & i.cell_mask
+	case Right:
+		i.moveRight(n)
+	case Left:
+		i.moveLeft(n)
+	case Output:
+		if i.Output != nil {
+			i.Output.WriteString(string(rune(i.mem[i.mem_ptr])))
+			i.bytes_written++
+		}
+	case Input:
+		if err := i.input(); err != nil {
+			i.program_ptr = o.pc
+			return err
+		}
+	case Ignore:
+	}
+	i.instructions += n
+	if n < uint64(o.n) {
+		i.program_ptr = o.pc + uint32(n)
+		return ErrStepLimit
+	}
+	return nil
+}
+
+// Execute a `[-]` or `[+]`, which is a `[` and then pairs of the `-` and the `]`
+// until the cell is 0
+func (i *Interpreter) clear(o *op, budget uint64) error {
+	v := i.mem[i.mem_ptr]
+	iterations := uint64(v)
+	if o.cmd == Increment && v != 0 {
+		iterations = uint64(i.cell_mask) + 1 - uint64(v)
+	}
+	total := 1 + 2*iterations
+	n := min(total, budget)
+
+	// The commands after the `[`: an odd number ends after a `-`
+	after := n - 1
+	changes, ends := after/2+after%2, after/2
+	if o.cmd == Increment {
+		i.mem[i.mem_ptr] = (v + uint32(changes)) & i.cell_mask
+	} else {
+		i.mem[i.mem_ptr] = (v - uint32(changes)) & i.cell_mask
+	}
+	if i.counts != nil {
+		i.counts[o.pc]++
+		i.counts[o.pc+1] += changes
+		i.counts[o.pc+2] += ends
+	}
+	i.instructions += n
+	if n < total {
+		i.program_ptr = o.pc + 1 + uint32(after%2)
+		return ErrStepLimit
+	}
+	return nil
+}
+
+func (i *Interpreter) moveRight(n uint64) {
+	if uint64(i.mem_ptr)+n < uint64(len(i.mem)) {
+		i.mem_ptr += uint32(n)
+		i.tape_high_water = max(i.tape_high_water, i.mem_ptr)
+		return
+	}
+	// Wraps around the end of the tape
+	for range n {
+		i.mem_ptr++
+		if i.mem_ptr >= uint32(len(i.mem)) {
+			i.mem_ptr = 0
+		}
+		i.tape_high_water = max(i.tape_high_water, i.mem_ptr)
+	}
+}
+
+func (i *Interpreter) moveLeft(n uint64) {
+	if n <= uint64(i.mem_ptr) {
+		i.mem_ptr -= uint32(n)
+		return
+	}
+	// Wraps around the start of the tape
+	for range n {
+		if i.mem_ptr == 0 {
+			i.mem_ptr = uint32(len(i.mem) - 1)
+			i.tape_high_water = i.mem_ptr
+		} else {
+			i.mem_ptr--
+		}
+	}
+}
+
+// Read a byte into the current cell. Returns errEndOfInput when the program
+// stops at the end of the input.
+func (i *Interpreter) input() error {
+	if i.Input == nil {
+		return nil
+	}
+	buff := make([]byte, 1)
+	if _, err := i.Input.Read(buff); err != nil {
+		if err != io.EOF {
+			return fmt.Errorf("reading input: %w", err)
+		}
+		i.log.Debug("end of input", "eof_mode", i.options.EOFMode)
+		switch i.options.EOFMode {
+		case EOFStop:
+			return errEndOfInput
+		case EOFZero:
+			i.mem[i.mem_ptr] = 0
+		case EOFMax:
+			i.mem[i.mem_ptr] = i.cell_mask
+		case EOFUnchanged:
+		}
+		return nil
+	}
+	i.mem[i.mem_ptr] = uint32(buff[0])
+	i.bytes_read++
+	return nil
+}
+
+// What RunContext returns for the error which stopped an op
+func stopError(err error) error {
+	if errors.Is(err, errEndOfInput) || errors.Is(err, errCancelled) {
+		return nil
+	}
+	return err
+}
+
+// Run the ops one after the other, jumping between the brackets
+func (i *Interpreter) runOptimized(ctx context.Context) error {
+	ops := optimize(i.Program)
+	for k := 0; k < len(ops); k++ {
+		o := &ops[k]
+		i.program_ptr = o.pc
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		if o.cmd == LoopStart || o.cmd == LoopEnd {
+			jump, err := i.bracket(o)
+			if err != nil {
+				return err
+			}
+			if jump {
+				k = o.jump
+			}
+		} else if err := i.exec(o); err != nil {
+			return stopError(err)
+		}
+		i.publishStatsDue()
+	}
+	i.program_ptr = uint32(len(i.Program))
+	return nil
+}
@@ -0,0 +1,233 @@
+package bf
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Settings of an interpreter. They are resolved from several sources, each of
+// which overrides the ones before it:
+//
+//  1. the defaults (DefaultOptions)
+//  2. the runtime options of the shim's runtime handler
+//  3. BF_* environment variables, e.g. BF_CELL_WIDTH=16 (ApplyEnv)
+//  4. io.containerd.bf.v1.* annotations of the container (ApplyAnnotations)
+//  5. command line flags, e.g. -cell-width 16 (RegisterFlags)
+//
+// Sources which don't apply to a command are skipped. Runtime options only
+// exist for the shim, and the standalone interpreter has no annotations. The
+// max steps and tape size of the runtime options are limits, which the later
+// sources can only lower.
+type Options struct {
+	// Width of a memory cell in bits: 8, 16 or 32
+	CellWidth int
+	// Number of cells on the tape
+	TapeSize int
+	// What `,` does at the end of the input
+	EOFMode EOFMode
+	// Engine which runs the program
+	Engine Engine
+	// Maximum number of instructions to execute. 0 means no limit.
+	MaxSteps uint64
+}
+
+type EOFMode string
+
+const (
+	// Stop the program
+	EOFStop EOFMode = "stop"
+	// Set the cell to 0
+	EOFZero EOFMode = "zero"
+	// Set the cell to its maximum value (-1)
+	EOFMax EOFMode = "max"
+	// Leave the cell as it is
+	EOFUnchanged EOFMode = "unchanged"
+)
+
+var EOFModes = []EOFMode{EOFStop, EOFZero, EOFMax, EOFUnchanged}
+
+type Engine string
+
+const (
+	// Scans for the matching bracket on every jump
+	EngineBasic Engine = "basic"
+	// Looks the matching bracket up in a table built before the program runs
+	EngineFast Engine = "fast"
+	// Runs the program as optimized instructions: runs of the same command
+	// execute at once, and so does a loop which clears a cell (`[-]`)
+	EngineOptimized Engine = "optimized"
+	// Compiles the optimized instructions to Go functions, with a Go loop for
+	// each loop of the program
+	EngineCompiled Engine = "compiled"
+)
+
+var Engines = []Engine{EngineBasic, EngineFast, EngineOptimized, EngineCompiled}
+
+var CellWidths = []int{8, 16, 32}
+
+// Returned by RunContext when the program runs for more than MaxSteps
+// instructions
+var ErrStepLimit = errors.New("step limit exceeded")
+
+func DefaultOptions() Options {
+	return Options{
+		CellWidth: 8,
+		TapeSize:  30_000,
+		EOFMode:   EOFStop,
+		Engine:    EngineBasic,
+	}
+}
+
+func (o Options) Validate() error {
+	if !slices.Contains(CellWidths, o.CellWidth) {
+		return fmt.Errorf("invalid cell width %d, expected one of %v", o.CellWidth, CellWidths)
+	}
+	if o.TapeSize <= 0 {
+		return fmt.Errorf("invalid tape size %d", o.TapeSize)
+	}
+	if !slices.Contains(EOFModes, o.EOFMode) {
+		return fmt.Errorf("invalid eof mode %q, expected one of %v", o.EOFMode, EOFModes)
+	}
+	if !slices.Contains(Engines, o.Engine) {
+		return fmt.Errorf("invalid engine %q, expected one of %v", o.Engine, Engines)
+	}
+	return nil
+}
+
+// Names of the options. They are used as the flag names, the annotation names
+// without AnnotationPrefix and the environment variable names without EnvPrefix
+// (upper case, with underscores).
+const (
+	CellWidthOption = "cell-width"
+	TapeSizeOption  = "tape-size"
+	EOFModeOption   = "eof-mode"
+	EngineOption    = "engine"
+	MaxStepsOption  = "max-steps"
+)
+
+var OptionNames = []string{CellWidthOption, TapeSizeOption, EOFModeOption, EngineOption, MaxStepsOption}
+
+const AnnotationPrefix = "io.containerd.bf.v1."
+const EnvPrefix = "BF_"
+
+// Annotation which sets the option
+func OptionAnnotation(name string) string {
+	return AnnotationPrefix + name
+}
+
+// Environment variable which sets the option
+func OptionEnv(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Set an option by name. The value is parsed, but not validated.
+func (o *Options) Set(name string, value string) error {
+	var err error
+	switch name {
+	case CellWidthOption:
+		o.CellWidth, err = strconv.Atoi(value)
+	case TapeSizeOption:
+		o.TapeSize, err = strconv.Atoi(value)
+	case EOFModeOption:
+		o.EOFMode = EOFMode(value)
+	case EngineOption:
+		o.Engine = Engine(value)
+	case MaxStepsOption:
+		o.MaxSteps, err = strconv.ParseUint(value, 10, 64)
+	default:
+		return fmt.Errorf("unknown option %q", name)
+	}
+	return err
+}
+
+// Value of an option by name, formatted the way Set parses it
+func (o Options) Get(name string) string {
+	switch name {
+	case CellWidthOption:
+		return strconv.Itoa(o.CellWidth)
+	case TapeSizeOption:
+		return strconv.Itoa(o.TapeSize)
+	case EOFModeOption:
+		return string(o.EOFMode)
+	case EngineOption:
+		return string(o.Engine)
+	case MaxStepsOption:
+		return strconv.FormatUint(o.MaxSteps, 10)
+	default:
+		return ""
+	}
+}
+
+// Apply the BF_* variables of an environment in the KEY=VALUE form of
+// os.Environ and the process.env of an OCI spec
+func (o *Options) ApplyEnv(env []string) error {
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		for _, name := range OptionNames {
+			if key == OptionEnv(name) {
+				if err := o.Set(name, value); err != nil {
+					return fmt.Errorf("environment variable %s: %w", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Apply the io.containerd.bf.v1.* annotations of a container
+func (o *Options) ApplyAnnotations(annotations map[string]string) error {
+	for _, name := range OptionNames {
+		annotation := OptionAnnotation(name)
+		if value, ok := annotations[annotation]; ok {
+			if err := o.Set(name, value); err != nil {
+				return fmt.Errorf("annotation %s: %w", annotation, err)
+			}
+		}
+	}
+	return nil
+}
+
+var optionUsage = map[string]string{
+	CellWidthOption: "width of a memory cell in bits (8, 16 or 32)",
+	TapeSizeOption:  "number of cells on the tape",
+	EOFModeOption:   "what reading past the end of the input does (stop, zero, max or unchanged)",
+	EngineOption:    "engine which runs the program (basic, fast, optimized or compiled)",
+	MaxStepsOption:  "stop the program after this many instructions (0 for no limit)",
+}
+
+type optionFlag struct {
+	options *Options
+	name    string
+}
+
+func (f optionFlag) String() string {
+	if f.options == nil {
+		return ""
+	}
+	return f.options.Get(f.name)
+}
+
+func (f optionFlag) Set(value string) error {
+	return f.options.Set(f.name, value)
+}
+
+// Register a flag for each option. The current values are the defaults of the
+// flags, so any other sources should be applied before parsing.
+func (o *Options) RegisterFlags(flagset *flag.FlagSet) {
+	for _, name := range OptionNames {
+		flagset.Var(optionFlag{o, name}, name, optionUsage[name])
+	}
+}
+
+// Command line flags which set all the options
+func (o Options) Flags() []string {
+	var flags []string
+	for _, name := range OptionNames {
+		flags = append(flags, "-"+name, o.Get(name))
+	}
+	return flags
+}
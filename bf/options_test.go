@@ -0,0 +1,43 @@
+package bf_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+func TestOptions_ApplyEnv(t *testing.T) {
+	options := bf.DefaultOptions()
+	utils.AssertNoError(t, options.ApplyEnv([]string{"HOME=/", "BF_CELL_WIDTH=16", "BF_EOF_MODE=zero", "BF_MAX_STEPS=10"}))
+	utils.AssertEqual(t, options.CellWidth, 16)
+	utils.AssertEqual(t, options.EOFMode, bf.EOFZero)
+	utils.AssertEqual(t, options.MaxSteps, 10)
+
+	utils.AssertError(t, options.ApplyEnv([]string{"BF_TAPE_SIZE=lots"}))
+}
+
+func TestOptions_ApplyAnnotations(t *testing.T) {
+	options := bf.DefaultOptions()
+	utils.AssertNoError(t, options.ApplyAnnotations(map[string]string{
+		"io.containerd.bf.v1.engine":    "fast",
+		"io.containerd.bf.v1.tape-size": "100",
+		"io.containerd.bf.v1.unknown":   "ignored",
+	}))
+	utils.AssertEqual(t, options.Engine, bf.EngineFast)
+	utils.AssertEqual(t, options.TapeSize, 100)
+}
+
+func TestOptions_Flags(t *testing.T) {
+	options := bf.DefaultOptions()
+	options.CellWidth = 32
+	options.EOFMode = bf.EOFMax
+	options.MaxSteps = 7
+
+	parsed := bf.DefaultOptions()
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	parsed.RegisterFlags(flagset)
+	utils.AssertNoError(t, flagset.Parse(options.Flags()))
+	utils.AssertEqual(t, parsed, options)
+}
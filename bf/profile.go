@@ -0,0 +1,252 @@
+package bf
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Count the executions of each instruction from now on. Call Profile after
+// the program has finished to get the counts.
+func (i *Interpreter) EnableProfiling() {
+	i.counts = make([]uint64, len(i.Program))
+}
+
+// Execution counts of the program, or nil if profiling is not enabled. Must
+// not be called while the program is running.
+func (i *Interpreter) Profile() *Profile {
+	if i.counts == nil {
+		return nil
+	}
+	return &Profile{
+		Program: i.Program,
+		Counts:  append([]uint64(nil), i.counts...),
+	}
+}
+
+// Execution counts of the instructions of a program
+type Profile struct {
+	Program []Command
+	// Number of executions of each instruction
+	Counts []uint64
+}
+
+// Execution counts of a loop
+type LoopProfile struct {
+	// Index of the `[` and the `]` of the loop in the program
+	Start, End int
+	// Number of times the program got to the loop
+	Entries uint64
+	// Number of times the body of the loop ran to the end
+	Iterations uint64
+	// Number of instructions executed in the loop, brackets included
+	Instructions uint64
+}
+
+// Execution counts of each loop, in the order of their `[` in the program.
+// Unmatched brackets are not loops.
+func (p *Profile) Loops() []LoopProfile {
+	jumps := matchBrackets(p.Program)
+	var loops []LoopProfile
+	for start, c := range p.Program {
+		end := int(jumps[start])
+		if c != LoopStart || end == start {
+			continue
+		}
+		loop := LoopProfile{
+			Start:      start,
+			End:        end,
+			Entries:    p.Counts[start],
+			Iterations: p.Counts[end],
+		}
+		for _, count := range p.Counts[start : end+1] {
+			loop.Instructions += count
+		}
+		loops = append(loops, loop)
+	}
+	return loops
+}
+
+// Write the profile in the pprof format (gzipped protobuf, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto), for
+// `go tool pprof`. Every instruction is a function named after its position in
+// the source file, and the loops around it are its callers, so the cumulative
+// counts of a loop cover everything executed in it.
+func (p *Profile) WritePprof(w io.Writer, filename string, positions []Position) error {
+	b := newPprofBuilder(filename)
+	jumps := matchBrackets(p.Program)
+
+	var loops []uint64 // locations of the enclosing loops, innermost last
+	var ends []int     // index of the `]` of the enclosing loops
+	for j, c := range p.Program {
+		pos := positions[j]
+		if c == LoopStart && int(jumps[j]) != j {
+			loops = append(loops, b.location(pos.String()+" loop", pos))
+			ends = append(ends, int(jumps[j]))
+		}
+
+		if p.Counts[j] > 0 {
+			stack := []uint64{b.location(pos.String()+" "+c.String(), pos)}
+			for k := len(loops) - 1; k >= 0; k-- {
+				stack = append(stack, loops[k])
+			}
+			b.sample(stack, int64(p.Counts[j]))
+		}
+
+		for len(ends) > 0 && ends[len(ends)-1] == j {
+			loops = loops[:len(loops)-1]
+			ends = ends[:len(ends)-1]
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.build()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Fields of profile.proto
+const (
+	pprofSampleType    = 1
+	pprofSample        = 2
+	pprofLocation      = 4
+	pprofFunction      = 5
+	pprofStringTable   = 6
+	pprofTimeNanos     = 9
+	pprofPeriodType    = 11
+	pprofPeriod        = 12
+	pprofTypeType      = 1
+	pprofTypeUnit      = 2
+	pprofSampleLoc     = 1
+	pprofSampleValue   = 2
+	pprofLocID         = 1
+	pprofLocLine       = 4
+	pprofLineFunction  = 1
+	pprofLineLine      = 2
+	pprofLineColumn    = 3
+	pprofFuncID        = 1
+	pprofFuncName      = 2
+	pprofFuncSystem    = 3
+	pprofFuncFilename  = 4
+	pprofFuncStartLine = 5
+)
+
+type pprofBuilder struct {
+	filename  int64
+	strings   []string
+	string_id map[string]int64
+	locations []byte
+	functions []byte
+	samples   []byte
+	ids       map[string]uint64
+}
+
+func newPprofBuilder(filename string) *pprofBuilder {
+	b := &pprofBuilder{
+		string_id: map[string]int64{},
+		ids:       map[string]uint64{},
+	}
+	// the first string has to be empty
+	b.str("")
+	b.filename = b.str(filename)
+	return b
+}
+
+func (b *pprofBuilder) str(s string) int64 {
+	if id, ok := b.string_id[s]; ok {
+		return id
+	}
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.string_id[s] = id
+	return id
+}
+
+// Location (with a function of the same name) at a position in the source
+func (b *pprofBuilder) location(name string, pos Position) uint64 {
+	if id, ok := b.ids[name]; ok {
+		return id
+	}
+	id := uint64(len(b.ids) + 1)
+	b.ids[name] = id
+
+	var function []byte
+	function = protowire.AppendTag(function, pprofFuncID, protowire.VarintType)
+	function = protowire.AppendVarint(function, id)
+	function = protowire.AppendTag(function, pprofFuncName, protowire.VarintType)
+	function = protowire.AppendVarint(function, uint64(b.str(name)))
+	function = protowire.AppendTag(function, pprofFuncSystem, protowire.VarintType)
+	function = protowire.AppendVarint(function, uint64(b.str(name)))
+	function = protowire.AppendTag(function, pprofFuncFilename, protowire.VarintType)
+	function = protowire.AppendVarint(function, uint64(b.filename))
+	function = protowire.AppendTag(function, pprofFuncStartLine, protowire.VarintType)
+	function = protowire.AppendVarint(function, uint64(pos.Line))
+	b.functions = protowire.AppendTag(b.functions, pprofFunction, protowire.BytesType)
+	b.functions = protowire.AppendBytes(b.functions, function)
+
+	var line []byte
+	line = protowire.AppendTag(line, pprofLineFunction, protowire.VarintType)
+	line = protowire.AppendVarint(line, id)
+	line = protowire.AppendTag(line, pprofLineLine, protowire.VarintType)
+	line = protowire.AppendVarint(line, uint64(pos.Line))
+	line = protowire.AppendTag(line, pprofLineColumn, protowire.VarintType)
+	line = protowire.AppendVarint(line, uint64(pos.Column))
+
+	var location []byte
+	location = protowire.AppendTag(location, pprofLocID, protowire.VarintType)
+	location = protowire.AppendVarint(location, id)
+	location = protowire.AppendTag(location, pprofLocLine, protowire.BytesType)
+	location = protowire.AppendBytes(location, line)
+	b.locations = protowire.AppendTag(b.locations, pprofLocation, protowire.BytesType)
+	b.locations = protowire.AppendBytes(b.locations, location)
+
+	return id
+}
+
+// Sample with the stack of locations, the leaf first
+func (b *pprofBuilder) sample(stack []uint64, count int64) {
+	var ids []byte
+	for _, id := range stack {
+		ids = protowire.AppendVarint(ids, id)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, pprofSampleLoc, protowire.BytesType)
+	sample = protowire.AppendBytes(sample, ids)
+	sample = protowire.AppendTag(sample, pprofSampleValue, protowire.BytesType)
+	sample = protowire.AppendBytes(sample, protowire.AppendVarint(nil, uint64(count)))
+	b.samples = protowire.AppendTag(b.samples, pprofSample, protowire.BytesType)
+	b.samples = protowire.AppendBytes(b.samples, sample)
+}
+
+func (b *pprofBuilder) valueType(typ string, unit string) []byte {
+	var value_type []byte
+	value_type = protowire.AppendTag(value_type, pprofTypeType, protowire.VarintType)
+	value_type = protowire.AppendVarint(value_type, uint64(b.str(typ)))
+	value_type = protowire.AppendTag(value_type, pprofTypeUnit, protowire.VarintType)
+	value_type = protowire.AppendVarint(value_type, uint64(b.str(unit)))
+	return value_type
+}
+
+func (b *pprofBuilder) build() []byte {
+	var profile []byte
+	profile = protowire.AppendTag(profile, pprofSampleType, protowire.BytesType)
+	profile = protowire.AppendBytes(profile, b.valueType("instructions", "count"))
+	profile = append(profile, b.samples...)
+	profile = append(profile, b.locations...)
+	profile = append(profile, b.functions...)
+	profile = protowire.AppendTag(profile, pprofTimeNanos, protowire.VarintType)
+	profile = protowire.AppendVarint(profile, uint64(time.Now().UnixNano()))
+	profile = protowire.AppendTag(profile, pprofPeriodType, protowire.BytesType)
+	profile = protowire.AppendBytes(profile, b.valueType("instructions", "count"))
+	profile = protowire.AppendTag(profile, pprofPeriod, protowire.VarintType)
+	profile = protowire.AppendVarint(profile, 1)
+	// all strings are known by now
+	for _, s := range b.strings {
+		profile = protowire.AppendTag(profile, pprofStringTable, protowire.BytesType)
+		profile = protowire.AppendString(profile, s)
+	}
+	return profile
+}
@@ -0,0 +1,53 @@
+package bf_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+func TestProfile(t *testing.T) {
+	// 3 iterations of the outer loop, each with 2 of the inner one
+	source := "+++[>++[-]<-]"
+	interpreter := bf.NewInterpreter(bf.Lex(source), nil, nil)
+	utils.Assert(t, interpreter.Profile() == nil, "profiling should be off by default")
+	interpreter.EnableProfiling()
+	utils.AssertNoError(t, interpreter.Run())
+
+	profile := interpreter.Profile()
+	utils.AssertEqual(t, profile.Counts[0], 1)
+	utils.AssertEqual(t, profile.Counts[3], 1)
+
+	loops := profile.Loops()
+	utils.AssertEqual(t, len(loops), 2)
+	utils.AssertEqual(t, loops[0], bf.LoopProfile{Start: 3, End: 12, Entries: 1, Iterations: 3, Instructions: 34})
+	utils.AssertEqual(t, loops[1], bf.LoopProfile{Start: 7, End: 9, Entries: 3, Iterations: 6, Instructions: 15})
+
+	var total uint64
+	for _, count := range profile.Counts {
+		total += count
+	}
+	utils.AssertEqual(t, total, interpreter.Stats().Instructions)
+}
+
+func TestProfile_WritePprof(t *testing.T) {
+	source := "+\n[-]"
+	interpreter := bf.NewInterpreter(bf.Lex(source), nil, nil)
+	interpreter.EnableProfiling()
+	utils.AssertNoError(t, interpreter.Run())
+
+	var buf bytes.Buffer
+	utils.AssertNoError(t, interpreter.Profile().WritePprof(&buf, "test.bf", bf.Positions(source)))
+
+	gz, err := gzip.NewReader(&buf)
+	utils.AssertNoError(t, err)
+	data, err := io.ReadAll(gz)
+	utils.AssertNoError(t, err)
+	for _, s := range []string{"test.bf", "instructions", "1:1 +", "2:1 loop", "2:2 -"} {
+		utils.Assert(t, bytes.Contains(data, []byte(s)), "profile should contain "+s)
+	}
+}
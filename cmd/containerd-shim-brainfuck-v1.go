@@ -4,36 +4,85 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	"github.com/MarcinKonowalczyk/runbf/sandbox"
 	bf_shim "github.com/MarcinKonowalczyk/runbf/shim"
 
 	"github.com/containerd/containerd/v2/pkg/shim"
 )
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
-
 	// Maybe hijack the shim to run as brainfuck interpreter
 	brainfuck, args := isBrainfuckArg(os.Args[1:])
 
 	if brainfuck {
-		err := runBrainfuck(ctx, args)
-		if err != nil {
-			fmt.Println("Error running brainfuck:", err)
-		}
+		os.Exit(mainBrainfuck(args))
 	} else {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
 		shim.Run(ctx, bf_shim.NewManager("io.containerd.bf.v1"))
 	}
 }
 
+// Run the brainfuck interpreter and return the exit code of the process. When
+// the interpreter gets stopped by one of process.StopSignals the exit code is
+// 128+signal.
+func mainBrainfuck(args []string) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, process.StopSignals...)
+	defer signal.Stop(sigs)
+
+	caught := make(chan syscall.Signal, 1)
+	go func() {
+		sig, ok := (<-sigs).(syscall.Signal)
+		if ok {
+			caught <- sig
+			cancel()
+		}
+	}()
+
+	err := runBrainfuck(ctx, args)
+
+	select {
+	case sig := <-caught:
+		logger.Debug("stopped by signal", "signal", sig.String())
+		return process.SignalExitCode(sig)
+	default:
+	}
+
+	if err != nil {
+		logger.Error("running brainfuck", "error", err)
+		fmt.Println("Error running brainfuck:", err)
+		return 1
+	}
+	return 0
+}
+
 ///////////////
 
 var filename string
+var metrics string
+var profile string
+var startFifo string
+var sandboxed bool
+var hold bool
+var logFd int
+var logLevel slog.Level
+var options = bf.DefaultOptions()
+
+// Logs to stderr until the flags say otherwise
+var logger = bf.NewLogger(os.Stderr, slog.LevelInfo, false)
 
 func isBrainfuckArg(args []string) (bool, []string) {
 	for i, arg := range args {
@@ -45,9 +94,35 @@ func isBrainfuckArg(args []string) (bool, []string) {
 }
 
 func parseBrainfuckFlags(args []string) error {
+	// Flags win over the environment
+	if err := options.ApplyEnv(os.Environ()); err != nil {
+		return fmt.Errorf("invalid argument: %w", err)
+	}
+	level, err := bf.LogLevelFromEnv()
+	if err != nil {
+		return fmt.Errorf("invalid argument: %s: %w", bf.LogLevelEnv, err)
+	}
+
 	my_flagset := flag.NewFlagSet("brainfuck", flag.ExitOnError)
 	my_flagset.StringVar(&filename, "file", "", "brainfuck source file")
-	return my_flagset.Parse(args)
+	my_flagset.StringVar(&metrics, "metrics", "", "periodically write interpreter metrics to this file")
+	my_flagset.StringVar(&profile, "profile", "", "write a pprof profile of the instructions executed to this file")
+	my_flagset.StringVar(&startFifo, "start-fifo", "", "wait for this fifo to be opened for writing before running")
+	my_flagset.BoolVar(&sandboxed, "sandbox", false, "run the program under a seccomp allowlist with no_new_privs")
+	my_flagset.BoolVar(&hold, "hold", false, "run no program and wait to be killed (for pod sandbox containers)")
+	my_flagset.IntVar(&logFd, "log-fd", 0, "write json logs to this file descriptor instead of text logs to stderr")
+	my_flagset.TextVar(&logLevel, "log-level", level, "log level (debug, info, warn or error)")
+	options.RegisterFlags(my_flagset)
+	if err := my_flagset.Parse(args); err != nil {
+		return err
+	}
+
+	if logFd != 0 {
+		logger = bf.NewLogger(os.NewFile(uintptr(logFd), "log"), logLevel, true)
+	} else {
+		logger = bf.NewLogger(os.Stderr, logLevel, false)
+	}
+	return nil
 }
 
 func runBrainfuck(ctx context.Context, args []string) error {
@@ -56,17 +131,130 @@ func runBrainfuck(ctx context.Context, args []string) error {
 		return err
 	}
 
-	if filename == "" {
+	if filename == "" && !hold {
 		return fmt.Errorf("invalid argument: -file is required")
 	}
 
-	source, err := os.ReadFile(filename)
-	if err != nil {
+	var interpreter *bf.Interpreter
+	var source []byte
+	if !hold {
+		var err error
+		if source, err = os.ReadFile(filename); err != nil {
+			return err
+		}
+		interpreter = bf.NewInterpreter(bf.Lex(bf.PreLex(string(source))), os.Stdin, os.Stdout)
+		interpreter.SetLogger(logger)
+		if profile != "" {
+			interpreter.EnableProfiling()
+		}
+		if err := interpreter.SetOptions(options); err != nil {
+			return fmt.Errorf("invalid argument: %w", err)
+		}
+	}
+
+	if startFifo != "" {
+		if err := waitForStart(ctx, startFifo); err != nil {
+			return err
+		}
+		logger.Debug("started")
+		if ctx.Err() != nil {
+			// Killed before being started
+			return nil
+		}
+	}
+
+	// The sandbox does not allow opening files, so open the outputs first
+	var metrics_file, profile_file *os.File
+	if !hold && metrics != "" {
+		var err error
+		if metrics_file, err = bf_shim.OpenMetrics(metrics); err != nil {
+			return err
+		}
+		defer metrics_file.Close()
+	}
+	if !hold && profile != "" {
+		var err error
+		if profile_file, err = os.Create(profile); err != nil {
+			return fmt.Errorf("creating profile: %w", err)
+		}
+		defer profile_file.Close()
+	}
+
+	if sandboxed {
+		if err := sandbox.Apply(); err != nil {
+			return fmt.Errorf("sandboxing interpreter: %w", err)
+		}
+	}
+
+	if hold {
+		// Nothing to run. Keep the task alive until it gets killed.
+		logger.Debug("holding the pod sandbox open")
+		<-ctx.Done()
+		return nil
+	}
+
+	var ticker <-chan time.Time
+	if metrics_file != nil {
+		t := time.NewTicker(bf_shim.MetricsInterval)
+		defer t.Stop()
+		ticker = t.C
+	}
+
+	stopped, result := process.RunInterpreter(ctx, interpreter, ticker, func() {
+		writeMetrics(metrics_file, interpreter)
+	})
+	if !stopped {
+		logger.Warn("interpreter did not stop in time", "grace_period", process.TerminationGracePeriod)
+	}
+
+	if metrics_file != nil {
+		writeMetrics(metrics_file, interpreter)
+	}
+	// The profile stays empty when the interpreter was killed mid-instruction
+	if profile_file != nil && stopped {
+		writeProfile(profile_file, interpreter, string(source))
+	}
+
+	return result
+}
+
+// Block until the other end of the fifo is opened and closed again (that's the
+// shim starting the task), or the context is cancelled
+func waitForStart(ctx context.Context, path string) error {
+	started := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			started <- fmt.Errorf("opening start fifo: %w", err)
+			return
+		}
+		defer f.Close()
+		_, err = io.Copy(io.Discard, f)
+		started <- err
+	}()
+
+	select {
+	case err := <-started:
 		return err
+	case <-ctx.Done():
+		return nil
 	}
+}
 
-	// Run the brainfuck interpreter
-	bf.RunContext(ctx, string(source), os.Stdin, os.Stdout)
+func writeProfile(f *os.File, interpreter *bf.Interpreter, source string) {
+	if err := interpreter.Profile().WritePprof(f, filename, bf.Positions(source)); err != nil {
+		logger.Warn("writing profile", "path", profile, "error", err)
+	}
+}
 
-	return nil
+func writeMetrics(f *os.File, interpreter *bf.Interpreter) {
+	var cpuTime time.Duration
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err == nil {
+		cpuTime = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+	}
+	m := bf_shim.NewMetrics(interpreter.Stats(), cpuTime)
+	if err := bf_shim.WriteMetrics(f, m); err != nil {
+		logger.Warn("writing metrics", "path", metrics, "error", err)
+	}
 }
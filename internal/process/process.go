@@ -0,0 +1,119 @@
+// Package process has the parts of running the interpreter process which are
+// shared by the shim, the shim binary and runbf: the exit codes, checking
+// whether a process is still alive, and the loop which runs the interpreter
+// until it finishes or gets killed.
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+)
+
+// https://pubs.opengroup.org/onlinepubs/9699919799/utilities/V3_chap02.html#tag_18_21_18
+const ExitCodeSignal = 128
+
+// How long to wait for the interpreter to notice a termination signal before
+// exiting anyway (it might be blocked reading stdin).
+const TerminationGracePeriod = 100 * time.Millisecond
+
+// Signals which stop the interpreter of the shim. The default action of all of
+// them is to terminate the process, but the sandboxed interpreter is the init
+// process of its PID namespace, which the kernel doesn't deliver signals to
+// unless it handles them. So it handles them itself, and exits with the exit
+// code of a process killed by the signal. Signals which stop the process are
+// dropped, other than SIGSTOP.
+var StopSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGALRM,
+	syscall.SIGTERM,
+}
+
+// Exit code of a process killed by the signal
+func SignalExitCode(sig syscall.Signal) int {
+	return ExitCodeSignal + int(sig)
+}
+
+func IsAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// The POSIX standard specifies that a null-signal can be sent to check
+	// whether a PID is valid.
+	err := syscall.Kill(pid, syscall.Signal(0))
+	if err != nil && err != syscall.EPERM {
+		return false
+	}
+	// The process might not be our child, and its parent might not have reaped
+	// it yet
+	return !isZombie(pid)
+}
+
+func isZombie(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state comes after the command name, which is in parentheses and
+	// might itself contain spaces or parentheses
+	stat := string(data)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 || i+2 >= len(stat) {
+		return false
+	}
+	return stat[i+2] == 'Z'
+}
+
+// Poll until the process is gone. Returns false if it is still alive after
+// the timeout.
+func WaitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for IsAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Run the interpreter until the program finishes or the context is cancelled.
+// The interpreter checks the context between instructions, but it might be
+// blocked on a read from stdin, in which case we give up on it after
+// TerminationGracePeriod. onTick, if given, is called on every tick.
+//
+// Returns whether the interpreter stopped (and is done with its counts), and
+// the error of the program if it finished before the context was cancelled.
+func RunInterpreter(ctx context.Context, interpreter *bf.Interpreter, tick <-chan time.Time, onTick func()) (bool, error) {
+	finished := make(chan struct{})
+	var run_err error
+	go func() {
+		defer close(finished)
+		run_err = interpreter.RunContext(ctx)
+	}()
+
+	for {
+		select {
+		case <-finished:
+			return true, run_err
+		case <-tick:
+			onTick()
+		case <-ctx.Done():
+			select {
+			case <-finished:
+				return true, nil
+			case <-time.After(TerminationGracePeriod):
+				return false, nil
+			}
+		}
+	}
+}
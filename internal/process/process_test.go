@@ -0,0 +1,76 @@
+package process
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+func TestIsAlive(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	utils.AssertNoError(t, cmd.Start())
+	utils.Assert(t, IsAlive(cmd.Process.Pid), "expected the process to be alive")
+
+	// Killed, but not reaped yet
+	utils.AssertNoError(t, cmd.Process.Kill())
+	utils.Assert(t, WaitForExit(cmd.Process.Pid, 5*time.Second), "expected the zombie to count as gone")
+	cmd.Wait()
+
+	utils.Assert(t, !IsAlive(0), "pid 0 is never alive")
+}
+
+func TestSignalExitCode(t *testing.T) {
+	utils.AssertEqual(t, SignalExitCode(syscall.SIGTERM), 143)
+	utils.AssertEqual(t, SignalExitCode(syscall.SIGKILL), 137)
+}
+
+func newInterpreter(t *testing.T, source string, input io.Reader) *bf.Interpreter {
+	var out strings.Builder
+	interpreter := bf.NewInterpreter(bf.Lex(bf.PreLex(source)), input, &out)
+	utils.AssertNoError(t, interpreter.SetOptions(bf.DefaultOptions()))
+	return interpreter
+}
+
+func TestRunInterpreter_Finishes(t *testing.T) {
+	stopped, err := RunInterpreter(context.Background(), newInterpreter(t, "+++.", strings.NewReader("")), nil, nil)
+	utils.Assert(t, stopped, "expected the interpreter to stop")
+	utils.AssertNoError(t, err)
+}
+
+func TestRunInterpreter_Cancelled(t *testing.T) {
+	// Loops forever, but checks the context between instructions
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stopped, err := RunInterpreter(ctx, newInterpreter(t, "+[]", strings.NewReader("")), nil, nil)
+	utils.Assert(t, stopped, "expected the interpreter to stop")
+	utils.AssertNoError(t, err)
+}
+
+func TestRunInterpreter_BlockedOnStdin(t *testing.T) {
+	// Nothing ever gets written, so the read never returns
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stopped, _ := RunInterpreter(ctx, newInterpreter(t, ",", r), nil, nil)
+	utils.Assert(t, !stopped, "expected the interpreter to be given up on")
+}
+
+func TestRunInterpreter_Ticks(t *testing.T) {
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ticked := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-ticked
+		cancel()
+	}()
+	RunInterpreter(ctx, newInterpreter(t, "+[]", strings.NewReader("")), tick, func() { close(ticked) })
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/oci"
+)
+
+const usage = `runbf is an OCI runtime for brainfuck bundles
+
+Usage: runbf [global options] <command> [command options] <container-id>
+
+Commands:
+  create   create a container
+  start    start the program of a created container
+  state    output the state of a container
+  kill     send a signal to the init process of a container
+  delete   delete a container
+  run      create, start, wait for and delete a container
+
+Global options:
+`
+
+var root string
+var logFile string
+var logFormat string
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&root, "root", oci.DefaultRoot, "root directory for the state of the containers")
+	flag.StringVar(&logFile, "log", "", "write errors to this file instead of stderr")
+	flag.StringVar(&logFormat, "log-format", "text", "format of the log (text or json)")
+	// Accepted for compatibility with runc, but ignored
+	flag.Bool("debug", false, "ignored")
+	flag.Bool("systemd-cgroup", false, "ignored")
+	flag.String("rootless", "auto", "ignored")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	// The init process of a container is this binary too
+	if command == "init" {
+		os.Exit(runInit(args))
+	}
+
+	code, err := runCommand(oci.NewRuntime(root), command, args)
+	if err != nil {
+		logError(err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+func runCommand(r *oci.Runtime, command string, args []string) (int, error) {
+	switch command {
+	case "create":
+		id, opts, err := parseCreateFlags("create", args)
+		if err != nil {
+			return 2, err
+		}
+		_, err = r.Create(id, opts)
+		return 0, err
+	case "run":
+		id, opts, err := parseCreateFlags("run", args)
+		if err != nil {
+			return 2, err
+		}
+		return r.Run(id, opts)
+	case "start":
+		id, err := parseIdFlags("start", args)
+		if err != nil {
+			return 2, err
+		}
+		return 0, r.Start(id)
+	case "state":
+		id, err := parseIdFlags("state", args)
+		if err != nil {
+			return 2, err
+		}
+		state, err := r.State(id)
+		if err != nil {
+			return 1, err
+		}
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return 1, err
+		}
+		fmt.Println(string(data))
+		return 0, nil
+	case "kill":
+		flagset := flag.NewFlagSet("kill", flag.ExitOnError)
+		var all bool
+		flagset.BoolVar(&all, "all", false, "send the signal to all processes of the container")
+		flagset.BoolVar(&all, "a", false, "alias of -all")
+		if err := flagset.Parse(args); err != nil {
+			return 2, err
+		}
+		if flagset.NArg() < 1 || flagset.NArg() > 2 {
+			return 2, fmt.Errorf("kill: expected <container-id> [signal]")
+		}
+		sig := syscall.SIGTERM
+		if flagset.NArg() == 2 {
+			var err error
+			if sig, err = oci.ParseSignal(flagset.Arg(1)); err != nil {
+				return 2, err
+			}
+		}
+		return 0, r.Kill(flagset.Arg(0), sig, all)
+	case "delete":
+		flagset := flag.NewFlagSet("delete", flag.ExitOnError)
+		force := flagset.Bool("force", false, "kill the container if it is still running")
+		if err := flagset.Parse(args); err != nil {
+			return 2, err
+		}
+		if flagset.NArg() != 1 {
+			return 2, fmt.Errorf("delete: expected <container-id>")
+		}
+		return 0, r.Delete(flagset.Arg(0), *force)
+	default:
+		return 2, fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func parseCreateFlags(name string, args []string) (string, oci.CreateOpts, error) {
+	var opts oci.CreateOpts
+	flagset := flag.NewFlagSet(name, flag.ExitOnError)
+	flagset.StringVar(&opts.Bundle, "bundle", ".", "path to the bundle directory")
+	flagset.StringVar(&opts.Bundle, "b", ".", "alias of -bundle")
+	flagset.StringVar(&opts.PidFile, "pid-file", "", "write the pid of the init process to this file")
+	flagset.StringVar(&opts.ConsoleSocket, "console-socket", "", "unix socket on which to send the pty master")
+	// Accepted for compatibility with runc, but ignored
+	flagset.Bool("no-pivot", false, "ignored")
+	flagset.Bool("no-new-keyring", false, "ignored")
+	flagset.Int("preserve-fds", 0, "ignored")
+	flagset.Bool("detach", false, "ignored")
+	flagset.Bool("d", false, "ignored")
+	if err := flagset.Parse(args); err != nil {
+		return "", opts, err
+	}
+	if flagset.NArg() != 1 {
+		return "", opts, fmt.Errorf("%s: expected <container-id>", name)
+	}
+	return flagset.Arg(0), opts, nil
+}
+
+func parseIdFlags(name string, args []string) (string, error) {
+	flagset := flag.NewFlagSet(name, flag.ExitOnError)
+	if err := flagset.Parse(args); err != nil {
+		return "", err
+	}
+	if flagset.NArg() != 1 {
+		return "", fmt.Errorf("%s: expected <container-id>", name)
+	}
+	return flagset.Arg(0), nil
+}
+
+func runInit(args []string) int {
+	var fifo, file string
+	options := bf.DefaultOptions()
+	flagset := flag.NewFlagSet("init", flag.ExitOnError)
+	flagset.StringVar(&fifo, "fifo", "", "exec fifo to wait on")
+	flagset.StringVar(&file, "file", "", "brainfuck source file")
+	options.RegisterFlags(flagset)
+	if err := flagset.Parse(args); err != nil {
+		return 2
+	}
+	if err := options.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid interpreter options:", err)
+		return 2
+	}
+	return oci.Init(fifo, file, options)
+}
+
+// Report an error the way container managers expect it from runc. With
+// -log-format=json each error is a json object on its own line.
+func logError(err error) {
+	out := os.Stderr
+	if logFile != "" {
+		f, ferr := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if ferr == nil {
+			defer f.Close()
+			out = f
+		}
+	}
+
+	if logFormat == "json" {
+		data, _ := json.Marshal(map[string]string{
+			"level": "error",
+			"msg":   err.Error(),
+			"time":  time.Now().Format(time.RFC3339),
+		})
+		fmt.Fprintln(out, string(data))
+	} else {
+		fmt.Fprintln(out, "runbf:", err)
+	}
+
+	if out != os.Stderr {
+		// Also tell the user directly
+		fmt.Fprintln(os.Stderr, "runbf:", err)
+	}
+}
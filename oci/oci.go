@@ -0,0 +1,481 @@
+// Package oci implements the OCI runtime command line interface
+// (https://github.com/opencontainers/runtime-spec/blob/main/runtime.md) for
+// brainfuck bundles, so that they can be run by runc-compatible container
+// managers such as podman or CRI-O.
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	bf_shim "github.com/MarcinKonowalczyk/runbf/shim"
+	"github.com/containerd/console"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// How often `start` checks whether the init process is still alive while it
+// waits on the exec fifo
+const execFifoPollInterval = 100 * time.Millisecond
+
+// How long `delete --force` waits for the init process to die
+const deleteTimeout = 5 * time.Second
+
+const DefaultRoot = "/run/runbf"
+
+type Runtime struct {
+	// Directory in which the state of the containers is kept
+	Root string
+}
+
+func NewRuntime(root string) *Runtime {
+	if root == "" {
+		root = DefaultRoot
+	}
+	return &Runtime{Root: root}
+}
+
+type CreateOpts struct {
+	// Path to the bundle directory
+	Bundle string
+	// Write the pid of the init process to this file
+	PidFile string
+	// Unix socket on which to send the master end of the pty, if the process
+	// asks for a terminal
+	ConsoleSocket string
+}
+
+// Create a container. The init process is started, but waits on the exec fifo
+// until Start is called.
+func (r *Runtime) Create(id string, opts CreateOpts) (*exec.Cmd, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	// The bundle is recorded in the state, which is read from other directories
+	bundle, err := filepath.Abs(opts.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("resolving bundle path: %w", err)
+	}
+	opts.Bundle = bundle
+
+	config, err := bf_shim.ReadConfig(opts.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	options, err := interpreterOptions(config)
+	if err != nil {
+		return nil, fmt.Errorf("interpreter options: %w", err)
+	}
+
+	dir := r.containerDir(id)
+	if err := os.MkdirAll(r.Root, 0711); err != nil {
+		return nil, fmt.Errorf("creating root directory: %w", err)
+	}
+	if err := os.Mkdir(dir, 0711); err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("container %s already exists", id)
+		}
+		return nil, fmt.Errorf("creating container directory: %w", err)
+	}
+
+	cmd, err := r.create(id, config, options, opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// Resolve the interpreter options of a container from the BF_* variables of
+// its environment and its annotations (see bf.Options)
+func interpreterOptions(config *bf_shim.Config) (bf.Options, error) {
+	options := bf.DefaultOptions()
+	if err := options.ApplyEnv(config.Env); err != nil {
+		return options, err
+	}
+	if err := options.ApplyAnnotations(config.Annotations); err != nil {
+		return options, err
+	}
+	return options, options.Validate()
+}
+
+func (r *Runtime) create(id string, config *bf_shim.Config, options bf.Options, opts CreateOpts) (*exec.Cmd, error) {
+	fifo := r.execFifoPath(id)
+	if err := unix.Mkfifo(fifo, 0600); err != nil {
+		return nil, fmt.Errorf("creating exec fifo: %w", err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("getting executable of current process: %w", err)
+	}
+
+	args := []string{"init", "-fifo", fifo, "-file", config.FullPath()}
+	args = append(args, options.Flags()...)
+	cmd := exec.Command(self, args...)
+	cmd.Env = config.Env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if config.Spec.Process.Terminal {
+		if opts.ConsoleSocket == "" {
+			return nil, fmt.Errorf("process.terminal is set but no --console-socket was given")
+		}
+		pty, slave_path, err := console.NewPty()
+		if err != nil {
+			return nil, fmt.Errorf("creating pty: %w", err)
+		}
+		defer pty.Close()
+
+		slave, err := os.OpenFile(slave_path, os.O_RDWR|syscall.O_NOCTTY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("opening pty slave %s: %w", slave_path, err)
+		}
+		defer slave.Close()
+
+		if err := sendConsole(opts.ConsoleSocket, pty); err != nil {
+			return nil, err
+		}
+
+		cmd.Stdin = slave
+		cmd.Stdout = slave
+		cmd.Stderr = slave
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	} else {
+		// The container manager hands us the stdio it wants the container to have
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting init process: %w", err)
+	}
+
+	state := &containerState{
+		ID:          id,
+		Bundle:      opts.Bundle,
+		Pid:         cmd.Process.Pid,
+		Created:     time.Now(),
+		Annotations: config.Annotations,
+	}
+	if err := r.saveState(state); err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+
+	if opts.PidFile != "" {
+		if err := os.WriteFile(opts.PidFile, []byte(strconv.Itoa(state.Pid)), 0644); err != nil {
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("writing pid file: %w", err)
+		}
+	}
+
+	return cmd, nil
+}
+
+// Send the master end of the pty over the console socket, the same way runc does
+func sendConsole(socket string, pty console.Console) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("connecting to console socket %s: %w", socket, err)
+	}
+	defer conn.Close()
+
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("console socket %s is not a unix socket", socket)
+	}
+
+	name := []byte(pty.Name())
+	rights := unix.UnixRights(int(pty.Fd()))
+	if _, _, err := uc.WriteMsgUnix(name, rights, nil); err != nil {
+		return fmt.Errorf("sending pty over console socket: %w", err)
+	}
+	return nil
+}
+
+// Start the user program of a created container
+func (r *Runtime) Start(id string) error {
+	state, err := r.loadState(id)
+	if err != nil {
+		return err
+	}
+
+	if status := r.status(state); status != specs.StateCreated {
+		return fmt.Errorf("cannot start a container in the %s state", status)
+	}
+
+	// Opening the read end unblocks the init process waiting on the write end
+	fifo := r.execFifoPath(id)
+	f, err := openExecFifo(fifo, state.Pid)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.ReadAll(f); err != nil {
+		return fmt.Errorf("reading exec fifo: %w", err)
+	}
+
+	return os.Remove(fifo)
+}
+
+// Open the read end of the exec fifo and wait for the init process to write to
+// it. A blocking open would hang forever if the init process has died, since
+// nothing would ever open the write end, so wait with poll and give up once the
+// init process is gone.
+func openExecFifo(fifo string, pid int) (*os.File, error) {
+	fd, err := unix.Open(fifo, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening exec fifo: %w", &os.PathError{Op: "open", Path: fifo, Err: err})
+	}
+	f := os.NewFile(uintptr(fd), fifo)
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, int(execFifoPollInterval.Milliseconds()))
+		if err != nil && !errors.Is(err, unix.EINTR) {
+			f.Close()
+			return nil, fmt.Errorf("waiting on exec fifo: %w", err)
+		}
+		if n > 0 {
+			break
+		}
+		if !process.IsAlive(pid) {
+			f.Close()
+			return nil, fmt.Errorf("init process %d exited before the container was started", pid)
+		}
+	}
+
+	// The rest of the fifo is read with blocking reads
+	if err := unix.SetNonblock(fd, false); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading exec fifo: %w", err)
+	}
+	return f, nil
+}
+
+// State of the container, as printed by `runbf state`
+func (r *Runtime) State(id string) (*specs.State, error) {
+	state, err := r.loadState(id)
+	if err != nil {
+		return nil, err
+	}
+
+	status := r.status(state)
+	pid := state.Pid
+	if status == specs.StateStopped {
+		pid = 0
+	}
+
+	return &specs.State{
+		Version:     specs.Version,
+		ID:          state.ID,
+		Status:      status,
+		Pid:         pid,
+		Bundle:      state.Bundle,
+		Annotations: state.Annotations,
+	}, nil
+}
+
+// Send a signal to the init process of the container, or to all of its
+// processes
+func (r *Runtime) Kill(id string, sig syscall.Signal, all bool) error {
+	state, err := r.loadState(id)
+	if err != nil {
+		return err
+	}
+
+	if r.status(state) == specs.StateStopped {
+		return fmt.Errorf("container %s is not running", id)
+	}
+
+	if all {
+		// The init process is the leader of its own session (and process group)
+		return syscall.Kill(-state.Pid, sig)
+	}
+	return syscall.Kill(state.Pid, sig)
+}
+
+// Delete the container. A container which has not stopped yet is only deleted
+// with force, in which case it gets killed first.
+func (r *Runtime) Delete(id string, force bool) error {
+	// Checked before anything gets removed, even with force
+	if err := validateID(id); err != nil {
+		return err
+	}
+	state, err := r.loadState(id)
+	if err != nil {
+		if force {
+			// Nothing to clean up other than (maybe) the directory
+			return os.RemoveAll(r.containerDir(id))
+		}
+		return err
+	}
+
+	if status := r.status(state); status != specs.StateStopped {
+		if !force {
+			return fmt.Errorf("cannot delete a container in the %s state", status)
+		}
+		if err := syscall.Kill(-state.Pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("killing init process %d: %w", state.Pid, err)
+		}
+		if !process.WaitForExit(state.Pid, deleteTimeout) {
+			return fmt.Errorf("init process %d did not exit", state.Pid)
+		}
+	}
+
+	return os.RemoveAll(r.containerDir(id))
+}
+
+// Create and start the container, wait for it to exit and delete it. Returns
+// the exit code of the init process.
+func (r *Runtime) Run(id string, opts CreateOpts) (int, error) {
+	cmd, err := r.Create(id, opts)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := r.Start(id); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		r.Delete(id, true)
+		return -1, err
+	}
+
+	// Forward termination signals to the container
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return -1, err
+		}
+		status := exitErr.Sys().(syscall.WaitStatus)
+		if status.Signaled() {
+			exitCode = process.SignalExitCode(status.Signal())
+		} else {
+			exitCode = status.ExitStatus()
+		}
+	}
+
+	return exitCode, r.Delete(id, false)
+}
+
+// Body of the init process of a container. Waits for `start` on the exec fifo
+// and then runs the brainfuck program. Returns the exit code of the process.
+func Init(fifo string, filename string, options bf.Options) int {
+	// Before `start` returns, so that a kill right after it gets handled
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	// Blocks until `start` opens the other end
+	opened := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err == nil {
+			f.Write([]byte{0})
+			f.Close()
+		}
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening exec fifo:", err)
+			return 1
+		}
+	case sig := <-sigs:
+		// Killed before being started
+		return process.SignalExitCode(sig.(syscall.Signal))
+	}
+
+	if filename == "" {
+		// A pod sandbox container has no program. It only waits to be killed.
+		sig := <-sigs
+		return process.SignalExitCode(sig.(syscall.Signal))
+	}
+
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading program:", err)
+		return 1
+	}
+
+	interpreter := bf.NewInterpreter(bf.Lex(bf.PreLex(string(source))), os.Stdin, os.Stdout)
+	if err := interpreter.SetOptions(options); err != nil {
+		fmt.Fprintln(os.Stderr, "Error running program:", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	caught := make(chan syscall.Signal, 1)
+	go func() {
+		select {
+		case sig := <-sigs:
+			caught <- sig.(syscall.Signal)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	_, err = process.RunInterpreter(ctx, interpreter, nil, nil)
+
+	select {
+	case sig := <-caught:
+		return process.SignalExitCode(sig)
+	default:
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error running program:", err)
+		return 1
+	}
+	return 0
+}
+
+// Parse a signal given by number (9), name (KILL) or full name (SIGKILL)
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid signal %s", s)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown signal %s", s)
+	}
+	return sig, nil
+}
@@ -0,0 +1,185 @@
+package oci
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// The init process of a container is the executable of the runtime, which is
+// the test binary here
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		var fifo, file string
+		options := bf.DefaultOptions()
+		flagset := flag.NewFlagSet("init", flag.ExitOnError)
+		flagset.StringVar(&fifo, "fifo", "", "")
+		flagset.StringVar(&file, "file", "", "")
+		options.RegisterFlags(flagset)
+		flagset.Parse(os.Args[2:])
+		os.Exit(Init(fifo, file, options))
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, s := range []string{"9", "KILL", "SIGKILL", "kill"} {
+		sig, err := ParseSignal(s)
+		utils.AssertNoError(t, err)
+		utils.AssertEqual(t, sig, syscall.SIGKILL)
+	}
+	_, err := ParseSignal("NOTASIGNAL")
+	utils.AssertError(t, err)
+	_, err = ParseSignal("0")
+	utils.AssertError(t, err)
+}
+
+func TestState_SaveLoad(t *testing.T) {
+	r := NewRuntime(t.TempDir())
+	utils.AssertNoError(t, os.Mkdir(r.containerDir("c1"), 0711))
+	utils.AssertNoError(t, r.saveState(&containerState{
+		ID:     "c1",
+		Bundle: "/bundle",
+		Pid:    os.Getpid(),
+	}))
+
+	state, err := r.State("c1")
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, state.Bundle, "/bundle")
+	utils.AssertEqual(t, state.Status, specs.StateRunning)
+	utils.AssertEqual(t, state.Pid, os.Getpid())
+
+	_, err = r.State("c2")
+	utils.AssertError(t, err)
+}
+
+// Make a bundle with a program which loops until it gets killed
+func makeLoopBundle(t *testing.T, dir string) string {
+	bundle := filepath.Join(dir, "bundle")
+	utils.AssertNoError(t, os.MkdirAll(filepath.Join(bundle, "rootfs"), 0755))
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(bundle, "rootfs", "loop.bf"), []byte("+[]"), 0644))
+	config, err := json.Marshal(&specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"loop.bf"}, Cwd: "/"},
+	})
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(bundle, "config.json"), config, 0644))
+	return bundle
+}
+
+func TestRuntime_Lifecycle(t *testing.T) {
+	dir := t.TempDir()
+	bundle := makeLoopBundle(t, dir)
+
+	// The bundle is given relative to the working directory
+	t.Chdir(dir)
+	r := NewRuntime(filepath.Join(dir, "root"))
+	cmd, err := r.Create("c1", CreateOpts{Bundle: "bundle"})
+	utils.AssertNoError(t, err)
+
+	state, err := r.State("c1")
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, state.Status, specs.StateCreated)
+	utils.AssertEqual(t, state.Bundle, bundle)
+	utils.AssertEqual(t, state.Pid, cmd.Process.Pid)
+	utils.AssertError(t, r.Delete("c1", false))
+
+	utils.AssertNoError(t, r.Start("c1"))
+	state, err = r.State("c1")
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, state.Status, specs.StateRunning)
+	utils.AssertError(t, r.Start("c1"))
+
+	utils.AssertNoError(t, r.Kill("c1", syscall.SIGTERM, false))
+	cmd.Wait()
+	utils.AssertEqual(t, cmd.ProcessState.ExitCode(), process.SignalExitCode(syscall.SIGTERM))
+
+	state, err = r.State("c1")
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, state.Status, specs.StateStopped)
+	utils.AssertEqual(t, state.Pid, 0)
+	utils.AssertError(t, r.Kill("c1", syscall.SIGTERM, false))
+
+	utils.AssertNoError(t, r.Delete("c1", false))
+	_, err = r.State("c1")
+	utils.AssertError(t, err)
+}
+
+func TestRuntime_KillCreated(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRuntime(filepath.Join(dir, "root"))
+	cmd, err := r.Create("c1", CreateOpts{Bundle: makeLoopBundle(t, dir)})
+	utils.AssertNoError(t, err)
+
+	utils.AssertNoError(t, r.Kill("c1", syscall.SIGTERM, false))
+	cmd.Wait()
+	// Depending on whether the init process got to handle the signal
+	status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	utils.Assert(t, status.Signal() == syscall.SIGTERM || status.ExitStatus() == process.SignalExitCode(syscall.SIGTERM), "expected the init process to be terminated")
+	utils.AssertNoError(t, r.Delete("c1", false))
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"c1", "my-container_1.2", "a+b"} {
+		utils.AssertNoError(t, validateID(id))
+	}
+	for _, id := range []string{"", ".", "..", "../x", "a/b", "/abs", "a b"} {
+		utils.AssertError(t, validateID(id))
+	}
+}
+
+func TestRuntime_RejectsPathIDs(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRuntime(filepath.Join(dir, "root"))
+	utils.AssertNoError(t, os.MkdirAll(r.Root, 0711))
+	// A directory next to the root, which ../x would point at
+	victim := filepath.Join(dir, "x")
+	utils.AssertNoError(t, os.Mkdir(victim, 0755))
+
+	utils.AssertError(t, r.Delete("../x", true))
+	_, err := os.Stat(victim)
+	utils.AssertNoError(t, err)
+
+	_, err = r.State("../x")
+	utils.AssertError(t, err)
+	utils.AssertError(t, r.Start("../x"))
+	utils.AssertError(t, r.Kill("../x", syscall.SIGTERM, false))
+	_, err = r.Create("../x", CreateOpts{Bundle: makeLoopBundle(t, dir)})
+	utils.AssertError(t, err)
+}
+
+func TestOpenExecFifo_InitGone(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), execFifoFilename)
+	utils.AssertNoError(t, unix.Mkfifo(fifo, 0600))
+
+	// An init process which died without opening the fifo
+	cmd := exec.Command("true")
+	utils.AssertNoError(t, cmd.Run())
+
+	done := make(chan error, 1)
+	go func() {
+		f, err := openExecFifo(fifo, cmd.Process.Pid)
+		if err == nil {
+			f.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		utils.AssertError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("openExecFifo blocked although the init process is gone")
+	}
+}
@@ -0,0 +1,92 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const stateFilename = "state.json"
+const execFifoFilename = "exec.fifo"
+
+// Persistent state of a container, stored in <root>/<id>/state.json
+type containerState struct {
+	ID          string            `json:"id"`
+	Bundle      string            `json:"bundle"`
+	Pid         int               `json:"pid"`
+	Created     time.Time         `json:"created"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Container IDs allowed by runc. They name a directory under the root, so they
+// must not contain path separators or be . or ..
+var validID = regexp.MustCompile(`^[\w+\-\.]+$`)
+
+func validateID(id string) error {
+	if id == "" {
+		return fmt.Errorf("container id cannot be empty")
+	}
+	if !validID.MatchString(id) || id == "." || id == ".." {
+		return fmt.Errorf("invalid container id %q", id)
+	}
+	return nil
+}
+
+func (r *Runtime) containerDir(id string) string {
+	return filepath.Join(r.Root, id)
+}
+
+func (r *Runtime) execFifoPath(id string) string {
+	return filepath.Join(r.containerDir(id), execFifoFilename)
+}
+
+func (r *Runtime) loadState(id string) (*containerState, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(r.containerDir(id), stateFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("container %s does not exist", id)
+		}
+		return nil, err
+	}
+	var state containerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing state of container %s: %w", id, err)
+	}
+	return &state, nil
+}
+
+// Write the state file atomically, so that a concurrent `state` never sees a
+// partial write
+func (r *Runtime) saveState(state *containerState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(r.containerDir(state.ID), stateFilename)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Work out the status of the container from its init process. The container is
+// `created` while the init process waits on the exec fifo for `start`.
+func (r *Runtime) status(state *containerState) specs.ContainerState {
+	if !process.IsAlive(state.Pid) {
+		return specs.StateStopped
+	}
+	if _, err := os.Stat(r.execFifoPath(state.ID)); err == nil {
+		return specs.StateCreated
+	}
+	return specs.StateRunning
+}
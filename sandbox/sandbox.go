@@ -0,0 +1,9 @@
+// Package sandbox hardens the interpreter process. A bf program only ever
+// reads stdin, writes stdout/stderr and exits, so the process running it gets
+// its own namespaces, no_new_privs, and a seccomp allowlist which covers little
+// more than what the Go runtime itself needs.
+package sandbox
+
+import "errors"
+
+var ErrUnsupported = errors.New("sandboxing is not supported on this platform")
@@ -0,0 +1,13 @@
+//go:build linux
+
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// Legacy syscalls which only exist on amd64
+var archSyscalls = []uintptr{
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_EPOLL_WAIT,
+}
@@ -0,0 +1,215 @@
+//go:build amd64 || arm64
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into struct seccomp_data
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	// Low 32 bits of the first argument, on little endian architectures
+	offsetArg0 = 16
+)
+
+// clone(2) is only allowed for new threads, which is all the Go runtime uses it
+// for. The flags of a thread include CLONE_THREAD and none of these.
+const forbiddenCloneFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// Namespaces which are available on this host, as clone flags
+func Cloneflags() uintptr {
+	var flags uintptr
+	for ns, flag := range map[string]uintptr{
+		"mnt":  syscall.CLONE_NEWNS,
+		"net":  syscall.CLONE_NEWNET,
+		"pid":  syscall.CLONE_NEWPID,
+		"user": syscall.CLONE_NEWUSER,
+	} {
+		if _, err := os.Stat("/proc/self/ns/" + ns); err == nil {
+			flags |= flag
+		}
+	}
+	if flags&syscall.CLONE_NEWUSER != 0 && !userNamespacesEnabled() {
+		flags &^= syscall.CLONE_NEWUSER
+	}
+	return flags
+}
+
+func userNamespacesEnabled() bool {
+	data, err := os.ReadFile("/proc/sys/user/max_user_namespaces")
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(data)) != "0"
+}
+
+// Put the command in new namespaces. The user namespace is no isolation by
+// itself: the current user is mapped to root, or the credentials of the command
+// to themselves, so the process keeps its ids on the host and its access to
+// the files it needs (the program, its bundle and its stdio).
+func Configure(attr *syscall.SysProcAttr) {
+	attr.Cloneflags |= Cloneflags()
+	if attr.Cloneflags&syscall.CLONE_NEWUSER == 0 {
+		return
+	}
+
+	cred := attr.Credential
+	if cred == nil {
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+		return
+	}
+
+	attr.UidMappings = []syscall.SysProcIDMap{identityMap(cred.Uid)}
+	attr.GidMappings = []syscall.SysProcIDMap{identityMap(cred.Gid)}
+	for _, gid := range cred.Groups {
+		if !slices.ContainsFunc(attr.GidMappings, func(m syscall.SysProcIDMap) bool { return m.HostID == int(gid) }) {
+			attr.GidMappings = append(attr.GidMappings, identityMap(gid))
+		}
+	}
+	// setgroups(2) is only allowed in the namespace if we have groups to set
+	attr.GidMappingsEnableSetgroups = len(cred.Groups) > 0
+}
+
+func identityMap(id uint32) syscall.SysProcIDMap {
+	return syscall.SysProcIDMap{ContainerID: int(id), HostID: int(id), Size: 1}
+}
+
+// Set no_new_privs on the current process. It can't gain privileges through
+// execve(2) afterwards (setuid binaries, file capabilities).
+func noNewPrivs() error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("setting no_new_privs: %w", err)
+	}
+	return nil
+}
+
+// Set no_new_privs and install the seccomp allowlist on all threads of the
+// current process. Syscalls outside of the allowlist fail with EPERM.
+// no_new_privs is set unconditionally, since an unprivileged process can't
+// install a seccomp filter without it.
+func Apply() error {
+	if err := noNewPrivs(); err != nil {
+		return err
+	}
+
+	filter := buildFilter(allowedSyscalls())
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	// TSYNC, since the Go runtime has spawned a few threads by now
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("installing seccomp filter: %w", errno)
+	}
+	return nil
+}
+
+func allowedSyscalls() []uintptr {
+	return append(commonSyscalls, archSyscalls...)
+}
+
+// Syscalls the interpreter needs on every architecture. Nothing here opens,
+// creates or renames files, so it has to open all of its files up front:
+//   - read/write for stdio, and flock/ftruncate/pwrite for the metrics file
+//   - memory management, threads, signals and timers for the Go runtime, and
+//     what glibc needs to start a thread when the binary uses cgo
+//   - writev for the fatal errors of glibc
+//   - getrusage for the cpu time in the metrics
+//
+// clone is allowed too, but only for threads (see buildFilter).
+var commonSyscalls = []uintptr{
+	unix.SYS_READ,
+	unix.SYS_WRITE,
+	unix.SYS_WRITEV,
+	unix.SYS_PWRITE64,
+	unix.SYS_CLOSE,
+	unix.SYS_FSTAT,
+	unix.SYS_NEWFSTATAT,
+	unix.SYS_FCNTL,
+	unix.SYS_FLOCK,
+	unix.SYS_FTRUNCATE,
+	unix.SYS_MMAP,
+	unix.SYS_MUNMAP,
+	unix.SYS_MADVISE,
+	unix.SYS_MPROTECT,
+	unix.SYS_BRK,
+	unix.SYS_FUTEX,
+	unix.SYS_GETPID,
+	unix.SYS_GETTID,
+	unix.SYS_TGKILL,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EVENTFD2,
+	unix.SYS_PIPE2,
+	unix.SYS_GETRANDOM,
+	unix.SYS_RSEQ,
+	unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_GETRUSAGE,
+	unix.SYS_RESTART_SYSCALL,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+}
+
+// BPF program which checks the architecture and then the syscall number
+// against the allowlist. A clone which isn't in the allowlist is checked for
+// the flags of a thread. clone3 passes its flags in memory, which seccomp can't
+// look at, so it fails with ENOSYS, which makes glibc fall back to clone.
+func buildFilter(allowed []uintptr) []unix.SockFilter {
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+	}
+	for i, nr := range allowed {
+		// jump over the remaining comparisons, the clone checks and the EPERM to
+		// the ALLOW
+		remaining := len(allowed) - i - 1
+		filter = append(filter, jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), uint8(remaining+7), 0))
+	}
+	filter = append(filter,
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE3, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE, 0, 3),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArg0),
+		jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, unix.CLONE_THREAD, 0, 1),
+		jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, forbiddenCloneFlags, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+	)
+	return filter
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
@@ -0,0 +1,15 @@
+//go:build !linux || !(amd64 || arm64)
+
+package sandbox
+
+import "syscall"
+
+func Cloneflags() uintptr {
+	return 0
+}
+
+func Configure(attr *syscall.SysProcAttr) {}
+
+func Apply() error {
+	return ErrUnsupported
+}
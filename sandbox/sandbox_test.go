@@ -0,0 +1,65 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	"golang.org/x/sys/unix"
+)
+
+const sandboxedEnv = "BF_SANDBOX_TEST_CHILD"
+
+// The filter can't be removed once installed, so the test re-runs itself in a
+// child process which applies the sandbox
+func TestApply(t *testing.T) {
+	if os.Getenv(sandboxedEnv) != "" {
+		if err := Apply(); err != nil {
+			os.Exit(2)
+		}
+		// Allowed
+		if _, err := os.Stdout.WriteString("ok"); err != nil {
+			os.Exit(3)
+		}
+		// Not allowed
+		if _, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0); err != unix.EPERM {
+			os.Exit(4)
+		}
+		if _, err := unix.Open("/proc/self/status", unix.O_RDONLY, 0); err != unix.EPERM {
+			os.Exit(5)
+		}
+		// Without CLONE_SIGHAND the kernel would refuse this with EINVAL
+		if _, _, errno := unix.RawSyscall(unix.SYS_CLONE, unix.CLONE_THREAD|unix.CLONE_NEWNET, 0, 0); errno != unix.EPERM {
+			os.Exit(6)
+		}
+		if _, _, errno := unix.RawSyscall(unix.SYS_CLONE3, 0, 0, 0); errno != unix.ENOSYS {
+			os.Exit(7)
+		}
+		// The runtime can still start threads. Each locked goroutine holds on to
+		// its own thread until release is closed.
+		var started sync.WaitGroup
+		release := make(chan struct{})
+		for range 4 {
+			started.Add(1)
+			go func() {
+				runtime.LockOSThread()
+				started.Done()
+				<-release
+			}()
+		}
+		started.Wait()
+		close(release)
+		os.Exit(0)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApply$")
+	cmd.Env = append(os.Environ(), sandboxedEnv+"=1")
+	out, err := cmd.Output()
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, string(out), "ok")
+}
@@ -0,0 +1,130 @@
+package shim
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/cgroups/v3/cgroup2"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Variables, so that tests can use a directory of their own
+var cgroupMountpoint = "/sys/fs/cgroup"
+var unifiedCgroups = func() bool { return cgroups.Mode() == cgroups.Unified }
+
+var errCgroupsUnsupported = errors.New("cgroups v2 (unified hierarchy) is not available")
+
+// Cgroup of the init process of a task
+type cgroup struct {
+	path    string
+	manager *cgroup2.Manager
+	systemd bool
+}
+
+// Create the cgroup named by linux.cgroupsPath, with the resource limits from
+// the spec, and move the process into it. Returns nil without an error when
+// the spec doesn't ask for a cgroup.
+//
+// cgroupsPath is either a path in the cgroup filesystem (`/default/<id>`), or,
+// with the systemd cgroup driver, `slice:prefix:name`.
+func newCgroup(linux *specs.Linux, pid int) (*cgroup, error) {
+	if linux == nil || linux.CgroupsPath == "" {
+		return nil, nil
+	}
+	if !unifiedCgroups() {
+		return nil, errCgroupsUnsupported
+	}
+
+	path := linux.CgroupsPath
+	resources := toResources(linux.Resources)
+
+	if slice, group, ok := parseSystemdPath(path); ok {
+		manager, err := cgroup2.NewSystemd(slice, group, pid, resources)
+		if err != nil {
+			return nil, fmt.Errorf("creating systemd cgroup %s: %w", path, err)
+		}
+		return &cgroup{path: path, manager: manager, systemd: true}, nil
+	}
+
+	manager, err := cgroup2.NewManager(cgroupMountpoint, path, resources)
+	if err != nil {
+		return nil, fmt.Errorf("creating cgroup %s: %w", path, err)
+	}
+	if err := manager.AddProc(uint64(pid)); err != nil {
+		manager.Delete()
+		return nil, fmt.Errorf("adding process %d to cgroup %s: %w", pid, path, err)
+	}
+	return &cgroup{path: path, manager: manager}, nil
+}
+
+// Load an existing cgroup, for example when recovering a task
+func loadCgroup(path string) (*cgroup, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !unifiedCgroups() {
+		return nil, errCgroupsUnsupported
+	}
+	if slice, group, ok := parseSystemdPath(path); ok {
+		manager, err := cgroup2.LoadSystemd(slice, group)
+		if err != nil {
+			return nil, err
+		}
+		return &cgroup{path: path, manager: manager, systemd: true}, nil
+	}
+	manager, err := cgroup2.Load(path, cgroup2.WithMountpoint(cgroupMountpoint))
+	if err != nil {
+		return nil, err
+	}
+	return &cgroup{path: path, manager: manager}, nil
+}
+
+// Delete the cgroup of a task whose shim has gone away. A cgroup which no longer
+// exists is not an error.
+func deleteCgroupPath(path string) error {
+	cg, err := loadCgroup(path)
+	if err != nil || cg == nil {
+		return err
+	}
+	if err := cg.delete(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c *cgroup) update(resources *specs.LinuxResources) error {
+	return c.manager.Update(toResources(resources))
+}
+
+func (c *cgroup) delete() error {
+	if c.systemd {
+		return c.manager.DeleteSystemd()
+	}
+	return c.manager.Delete()
+}
+
+// `slice:prefix:name` -> (slice, prefix-name.scope)
+func parseSystemdPath(path string) (string, string, bool) {
+	parts := strings.Split(path, ":")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[0], parts[1] + "-" + parts[2] + ".scope", true
+}
+
+// A bf program can only use memory, cpu, and (in theory) processes, so those
+// are the only limits we apply
+func toResources(resources *specs.LinuxResources) *cgroup2.Resources {
+	if resources == nil {
+		return &cgroup2.Resources{}
+	}
+	r := cgroup2.ToResources(resources)
+	return &cgroup2.Resources{
+		CPU:    r.CPU,
+		Memory: r.Memory,
+		Pids:   r.Pids,
+	}
+}
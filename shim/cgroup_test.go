@@ -0,0 +1,59 @@
+package shim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Make an empty cgroup at path, in a cgroupfs of the test
+func fakeCgroup(t *testing.T, path string) string {
+	t.Helper()
+	mountpoint, unified := cgroupMountpoint, unifiedCgroups
+	cgroupMountpoint = t.TempDir()
+	unifiedCgroups = func() bool { return true }
+	t.Cleanup(func() { cgroupMountpoint, unifiedCgroups = mountpoint, unified })
+
+	dir := filepath.Join(cgroupMountpoint, path)
+	utils.AssertNoError(t, os.MkdirAll(dir, 0755))
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(dir, "cgroup.procs"), nil, 0644))
+	return dir
+}
+
+func TestDeleteCgroupPath(t *testing.T) {
+	dir := fakeCgroup(t, "/test/task")
+	utils.AssertNoError(t, deleteCgroupPath("/test/task"))
+	_, err := os.Stat(dir)
+	utils.Assert(t, os.IsNotExist(err), "expected the cgroup to be deleted")
+
+	// Deleting it again, or a task without a cgroup, is fine
+	utils.AssertNoError(t, deleteCgroupPath("/test/task"))
+	utils.AssertNoError(t, deleteCgroupPath(""))
+}
+
+func TestParseSystemdPath(t *testing.T) {
+	slice, group, ok := parseSystemdPath("system.slice:docker:abc")
+	utils.Assert(t, ok, "expected a systemd path")
+	utils.AssertEqual(t, slice, "system.slice")
+	utils.AssertEqual(t, group, "docker-abc.scope")
+
+	_, _, ok = parseSystemdPath("/default/abc")
+	utils.Assert(t, !ok, "expected a cgroupfs path")
+}
+
+func TestToResources(t *testing.T) {
+	limit := int64(1 << 20)
+	resources := toResources(&specs.LinuxResources{
+		Memory: &specs.LinuxMemory{Limit: &limit},
+		Pids:   &specs.LinuxPids{Limit: 1},
+		Devices: []specs.LinuxDeviceCgroup{
+			{Allow: false, Access: "rwm"},
+		},
+	})
+	utils.AssertEqual(t, *resources.Memory.Max, limit)
+	utils.AssertEqual(t, resources.Pids.Max, 1)
+	utils.AssertEqual(t, len(resources.Devices), 0)
+}
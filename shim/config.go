@@ -0,0 +1,303 @@
+package shim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const configFilename = "config.json"
+
+type Config struct {
+	// The full bundle config
+	Spec *specs.Spec
+
+	Root string
+	// Absolute path of the brainfuck program inside the rootfs. Empty for pod
+	// sandbox containers.
+	Entrypoint string
+	// Arguments following the program. Brainfuck has no argv, so these are
+	// not passed to the program.
+	Args []string
+	Path []string
+	Cwd  string
+	Env  []string
+
+	// Whether this is the sandbox (pause) container of a Kubernetes pod. It has
+	// no program to run and only holds the pod open.
+	PodSandbox bool
+
+	// The user the interpreter runs as. There is no NoNewPrivileges, since the
+	// sandbox of the interpreter always sets no_new_privs, whatever the spec
+	// says: seccomp filters can't be installed without it.
+	User specs.User
+
+	Annotations map[string]string
+	Mounts      []specs.Mount
+	Rlimits     []specs.POSIXRlimit
+	Hooks       *specs.Hooks
+}
+
+// Names under which an image can refer to the interpreter itself, for example
+// in `ENTRYPOINT ["brainfuck"]` followed by `CMD ["/prog.bf"]`
+var interpreterNames = []string{"brainfuck", "bf"}
+
+// Annotations set by the CRI plugin of containerd
+const (
+	criContainerTypeAnnotation = "io.kubernetes.cri.container-type"
+	criSandboxIDAnnotation     = "io.kubernetes.cri.sandbox-id"
+
+	criContainerTypeSandbox = "sandbox"
+)
+
+// /var/run/desktop-containerd/daemon/io.containerd.runtime.v2.task/moby/
+
+// ReadConfig reads the bundle config from the path and resolves the brainfuck
+// program to run.
+func ReadConfig(path string) (*Config, error) {
+	spec, err := readSpec(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateSpec(spec); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configFilename, err)
+	}
+
+	root := specRootPath(path, spec)
+
+	// Get the PATH environment variable
+	split_path := []string{}
+	for _, env := range spec.Process.Env {
+		if path, ok := strings.CutPrefix(env, "PATH="); ok {
+			// Split the PATH variable into a slice
+			split_path = strings.Split(path, ":")
+			break
+		}
+	}
+
+	cwd := spec.Process.Cwd
+	if cwd == "" {
+		cwd = "/"
+	}
+
+	config := &Config{
+		Spec: spec,
+		Root: root,
+		Path: split_path,
+		Cwd:  cwd,
+		Env:  spec.Process.Env,
+
+		User: spec.Process.User,
+
+		Annotations: spec.Annotations,
+		Mounts:      spec.Mounts,
+		Rlimits:     spec.Process.Rlimits,
+		Hooks:       spec.Hooks,
+	}
+
+	if spec.Annotations[criContainerTypeAnnotation] == criContainerTypeSandbox {
+		// The pause container of a pod. Whatever its image runs, there is no
+		// brainfuck in it.
+		config.PodSandbox = true
+		return config, nil
+	}
+
+	args := spec.Process.Args
+	if len(args) > 1 && isInterpreterName(args[0]) {
+		// ENTRYPOINT ["brainfuck"] + CMD ["prog.bf", ...]
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no program given in the ENTRYPOINT or CMD")
+	}
+
+	arg0 := args[0]
+
+	// check if the extension is .bf
+	if !(filepath.Ext(arg0) == ".bf" || filepath.Ext(arg0) == ".brainfuck") {
+		return nil, fmt.Errorf("entry point (%s) is not a .bf file", arg0)
+	}
+
+	entrypoint, err := resolveEntrypoint(root, cwd, split_path, arg0)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Entrypoint = entrypoint
+	config.Args = args[1:]
+	return config, nil
+}
+
+// The absolute path of the root filesystem of a bundle, from its config
+func rootPath(bundle string) (string, error) {
+	spec, err := readSpec(bundle)
+	if err != nil {
+		return "", err
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return "", fmt.Errorf("invalid config file %s: root.path is required", configFilename)
+	}
+	return specRootPath(bundle, spec), nil
+}
+
+func specRootPath(bundle string, spec *specs.Spec) string {
+	root := spec.Root.Path
+	if !filepath.IsAbs(root) {
+		// The root path is relative to the bundle
+		root = filepath.Join(bundle, root)
+	}
+	return root
+}
+
+// Read the bundle config without validating it
+func readSpec(path string) (*specs.Spec, error) {
+	filePath := filepath.Join(path, configFilename)
+	if _, err := os.Stat(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("config file %s not found", configFilename)
+		}
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", configFilename, err)
+	}
+	return &spec, nil
+}
+
+// Check the parts of the spec the shim relies on
+func validateSpec(spec *specs.Spec) error {
+	if spec.Version == "" {
+		return fmt.Errorf("ociVersion is required")
+	}
+	if !strings.HasPrefix(spec.Version, "1.") {
+		return fmt.Errorf("unsupported ociVersion %s", spec.Version)
+	}
+
+	if spec.Root == nil || spec.Root.Path == "" {
+		return fmt.Errorf("root.path is required")
+	}
+
+	if spec.Process == nil {
+		return fmt.Errorf("process is required")
+	}
+	if spec.Process.Cwd != "" && !filepath.IsAbs(spec.Process.Cwd) {
+		return fmt.Errorf("process.cwd %s is not an absolute path", spec.Process.Cwd)
+	}
+	for _, env := range spec.Process.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("process.env entry %q is not of the form KEY=value", env)
+		}
+	}
+	for _, rlimit := range spec.Process.Rlimits {
+		if _, ok := rlimitResources[rlimit.Type]; !ok {
+			return fmt.Errorf("process.rlimits: unknown resource %s", rlimit.Type)
+		}
+		if rlimit.Soft > rlimit.Hard {
+			return fmt.Errorf("process.rlimits %s: soft limit is greater than the hard limit", rlimit.Type)
+		}
+	}
+
+	for _, mount := range spec.Mounts {
+		if !filepath.IsAbs(mount.Destination) {
+			return fmt.Errorf("mount destination %s is not an absolute path", mount.Destination)
+		}
+	}
+
+	if spec.Hooks != nil {
+		hooks := [][]specs.Hook{
+			spec.Hooks.Prestart,
+			spec.Hooks.CreateRuntime,
+			spec.Hooks.CreateContainer,
+			spec.Hooks.StartContainer,
+			spec.Hooks.Poststart,
+			spec.Hooks.Poststop,
+		}
+		for _, hs := range hooks {
+			for _, hook := range hs {
+				if !filepath.IsAbs(hook.Path) {
+					return fmt.Errorf("hook path %s is not an absolute path", hook.Path)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) FullPath() string {
+	if c.Entrypoint == "" {
+		return ""
+	}
+	return inRoot(c.Root, c.Entrypoint)
+}
+
+func isInterpreterName(arg string) bool {
+	base := filepath.Base(arg)
+	for _, name := range interpreterNames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Join a path inside the container with the rootfs. The path can't escape the
+// rootfs with `..`.
+func inRoot(root, path string) string {
+	return filepath.Join(root, filepath.Clean("/"+path))
+}
+
+// Resolve the program to an absolute path inside the rootfs, the same way a
+// shell would:
+//   - absolute paths are used as they are
+//   - relative paths with a slash are resolved against the working directory
+//   - bare names are searched for on the PATH
+//
+// As a fallback for images built before PATH lookup existed, bare names which
+// are not on the PATH are resolved against the working directory.
+func resolveEntrypoint(root, cwd string, path []string, arg0 string) (string, error) {
+	var candidates []string
+	switch {
+	case filepath.IsAbs(arg0):
+		candidates = []string{arg0}
+	case strings.Contains(arg0, "/"):
+		candidates = []string{filepath.Join(cwd, arg0)}
+	default:
+		for _, dir := range path {
+			if dir == "" {
+				// An empty PATH entry means the working directory
+				dir = cwd
+			}
+			candidates = append(candidates, filepath.Join(dir, arg0))
+		}
+		candidates = append(candidates, filepath.Join(cwd, arg0))
+	}
+
+	for _, candidate := range candidates {
+		candidate = filepath.Clean("/" + candidate)
+		info, err := os.Stat(inRoot(root, candidate))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("checking script %s: %w", candidate, err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		return candidate, nil
+	}
+
+	return "", fmt.Errorf("script %s does not exist: %w", arg0, os.ErrNotExist)
+}
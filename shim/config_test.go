@@ -0,0 +1,192 @@
+package shim
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+// Make a bundle with a rootfs containing the given files, and a config.json
+// with the given process
+func makeBundle(t *testing.T, files []string, process map[string]any) string {
+	bundle := t.TempDir()
+	rootfs := filepath.Join(bundle, "rootfs")
+	for _, file := range files {
+		path := filepath.Join(rootfs, file)
+		utils.AssertNoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		utils.AssertNoError(t, os.WriteFile(path, []byte("+."), 0644))
+	}
+	data, err := json.Marshal(map[string]any{
+		"ociVersion": "1.2.0",
+		"root":       map[string]any{"path": "rootfs"},
+		"process":    process,
+	})
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(bundle, configFilename), data, 0644))
+	return bundle
+}
+
+func TestReadConfig_Absolute(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args": []string{"/hello.bf"},
+		"cwd":  "/",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.Entrypoint, "/hello.bf")
+	utils.AssertEqual(t, config.FullPath(), filepath.Join(bundle, "rootfs", "hello.bf"))
+}
+
+func TestReadConfig_RelativeToCwd(t *testing.T) {
+	bundle := makeBundle(t, []string{"app/progs/hello.bf"}, map[string]any{
+		"args": []string{"progs/hello.bf"},
+		"cwd":  "/app",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.Entrypoint, "/app/progs/hello.bf")
+}
+
+func TestReadConfig_SearchPath(t *testing.T) {
+	bundle := makeBundle(t, []string{"usr/bin/hello.bf", "hello.bf"}, map[string]any{
+		"args": []string{"hello.bf"},
+		"env":  []string{"PATH=/usr/local/bin:/usr/bin"},
+		"cwd":  "/",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.Entrypoint, "/usr/bin/hello.bf")
+}
+
+func TestReadConfig_BareNameFallsBackToCwd(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args": []string{"hello.bf"},
+		"env":  []string{"PATH=/usr/bin"},
+		"cwd":  "/",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.Entrypoint, "/hello.bf")
+}
+
+func TestReadConfig_EntrypointAndCmd(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args": []string{"brainfuck", "/hello.bf", "extra"},
+		"cwd":  "/",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.Entrypoint, "/hello.bf")
+	utils.AssertEqualArrays(t, config.Args, []string{"extra"})
+}
+
+func TestReadConfig_NoEscape(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args": []string{"../../hello.bf"},
+		"cwd":  "/",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.Entrypoint, "/hello.bf")
+}
+
+func TestReadConfig_NotFound(t *testing.T) {
+	bundle := makeBundle(t, []string{}, map[string]any{
+		"args": []string{"/hello.bf"},
+		"cwd":  "/",
+	})
+	_, err := ReadConfig(bundle)
+	utils.AssertError(t, err)
+}
+
+func TestReadConfig_ShortEnv(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args": []string{"/hello.bf"},
+		"env":  []string{"A=1", "PATH=/usr/bin"},
+		"cwd":  "/",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqualArrays(t, config.Path, []string{"/usr/bin"})
+}
+
+func TestReadConfig_Invalid(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args": []string{"/hello.bf"},
+		"env":  []string{"NOT_AN_ENV"},
+		"cwd":  "/",
+	})
+	_, err := ReadConfig(bundle)
+	utils.AssertError(t, err)
+}
+
+func TestReadConfig_UserAndRlimits(t *testing.T) {
+	bundle := makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args":            []string{"/hello.bf"},
+		"cwd":             "/",
+		"user":            map[string]any{"uid": 1000, "gid": 100},
+		"rlimits":         []map[string]any{{"type": "RLIMIT_CPU", "soft": 1, "hard": 1}},
+		"noNewPrivileges": true,
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, config.User.UID, 1000)
+	utils.AssertEqual(t, config.User.GID, 100)
+	utils.AssertEqual(t, len(config.Rlimits), 1)
+
+	bundle = makeBundle(t, []string{"hello.bf"}, map[string]any{
+		"args":    []string{"/hello.bf"},
+		"rlimits": []map[string]any{{"type": "RLIMIT_BOGUS", "soft": 1, "hard": 1}},
+	})
+	_, err = ReadConfig(bundle)
+	utils.AssertError(t, err)
+}
+
+// Add annotations to the config.json of a bundle made with makeBundle
+func annotateBundle(t *testing.T, bundle string, annotations map[string]string) {
+	path := filepath.Join(bundle, configFilename)
+	data, err := os.ReadFile(path)
+	utils.AssertNoError(t, err)
+	var config map[string]any
+	utils.AssertNoError(t, json.Unmarshal(data, &config))
+	config["annotations"] = annotations
+	data, err = json.Marshal(config)
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestReadConfig_PodSandbox(t *testing.T) {
+	bundle := makeBundle(t, nil, map[string]any{
+		"args": []string{"/pause"},
+		"cwd":  "/",
+	})
+	annotateBundle(t, bundle, map[string]string{
+		"io.kubernetes.cri.container-type": "sandbox",
+		"io.kubernetes.cri.sandbox-id":     "pod",
+	})
+	config, err := ReadConfig(bundle)
+	utils.AssertNoError(t, err)
+	utils.Assert(t, config.PodSandbox, "expected a pod sandbox")
+	utils.AssertEqual(t, config.FullPath(), "")
+}
+
+func TestRootPath(t *testing.T) {
+	bundle := makeBundle(t, nil, map[string]any{"args": []string{"/hello.bf"}})
+	root, err := rootPath(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, root, filepath.Join(bundle, "rootfs"))
+
+	data, err := json.Marshal(map[string]any{"ociVersion": "1.2.0", "root": map[string]any{"path": "/var/lib/root"}})
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(bundle, configFilename), data, 0644))
+	root, err = rootPath(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, root, "/var/lib/root")
+
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(bundle, configFilename), []byte(`{"ociVersion": "1.2.0"}`), 0644))
+	_, err = rootPath(bundle)
+	utils.AssertError(t, err)
+}
@@ -0,0 +1,106 @@
+package shim
+
+import (
+	buildinfo "runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+// Version and revision of the shim. They can be set at link time with
+// `-ldflags="-X 'github.com/MarcinKonowalczyk/runbf/shim.Version=v1.3.0'"`,
+// otherwise they come from the build info of the binary.
+var (
+	Version  string
+	Revision string
+)
+
+func buildVersion() (version string, revision string) {
+	version, revision = Version, Revision
+	info, ok := buildinfo.ReadBuildInfo()
+	if !ok {
+		return version, revision
+	}
+	if version == "" {
+		version = info.Main.Version
+	}
+	if revision == "" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	return version, revision
+}
+
+// Task APIs which do something. Exec, Pause, Resume and Checkpoint are not
+// implemented.
+var taskAPIs = []string{
+	"Create", "Start", "Delete", "State", "Kill", "Wait", "Pids", "Stats",
+	"Update", "CloseIO", "ResizePty", "Connect", "Shutdown",
+}
+
+// Keys of the bf specific features, which go in the annotations of the
+// features document
+const (
+	dialectsFeature    = "io.containerd.bf.v1.features.dialects"
+	cellWidthsFeature  = "io.containerd.bf.v1.features.cell-widths"
+	eofModesFeature    = "io.containerd.bf.v1.features.eof-modes"
+	enginesFeature     = "io.containerd.bf.v1.features.engines"
+	annotationsFeature = "io.containerd.bf.v1.features.annotations"
+	taskAPIsFeature    = "io.containerd.bf.v1.features.task-apis"
+	versionFeature     = "io.containerd.bf.v1.features.version"
+)
+
+// Features of the runtime, in the format of the OCI features document (as
+// output by `runc features`). Lists are comma separated.
+func Features() *features.Features {
+	version, _ := buildVersion()
+
+	var cell_widths []string
+	for _, width := range bf.CellWidths {
+		cell_widths = append(cell_widths, strconv.Itoa(width))
+	}
+	var annotations []string
+	annotations = append(annotations, criContainerTypeAnnotation, profileAnnotation)
+	annotations = append(annotations, groupAnnotations...)
+	for _, name := range bf.OptionNames {
+		annotations = append(annotations, bf.OptionAnnotation(name))
+	}
+
+	yes := true
+	return &features.Features{
+		OCIVersionMin: "1.0.0",
+		OCIVersionMax: specs.Version,
+		Hooks: []string{
+			"prestart", "createRuntime", "createContainer", "startContainer", "poststart", "poststop",
+		},
+		Linux: &features.Linux{
+			Cgroup: &features.Cgroup{
+				V2:      &yes,
+				Systemd: &yes,
+			},
+		},
+		Annotations: map[string]string{
+			versionFeature:     version,
+			dialectsFeature:    "brainfuck",
+			cellWidthsFeature:  strings.Join(cell_widths, ","),
+			eofModesFeature:    joinStrings(bf.EOFModes),
+			enginesFeature:     joinStrings(bf.Engines),
+			annotationsFeature: strings.Join(annotations, ","),
+			taskAPIsFeature:    strings.Join(taskAPIs, ","),
+		},
+	}
+}
+
+func joinStrings[T ~string](values []T) string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = string(v)
+	}
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,29 @@
+package shim
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+func TestInfo_Features(t *testing.T) {
+	info, err := NewManager("io.containerd.bf.v1").Info(context.Background(), strings.NewReader(""))
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, info.Name, "io.containerd.bf.v1")
+
+	v, err := typeurl.UnmarshalAny(info.Features)
+	utils.AssertNoError(t, err)
+	f, ok := v.(*features.Features)
+	utils.Assert(t, ok, "features should decode to *features.Features")
+
+	utils.AssertEqual(t, f.Annotations[dialectsFeature], "brainfuck")
+	utils.AssertEqual(t, f.Annotations[cellWidthsFeature], "8,16,32")
+	utils.AssertEqual(t, f.Annotations[enginesFeature], "basic,fast,optimized,compiled")
+	utils.Assert(t, strings.Contains(f.Annotations[annotationsFeature], bf.OptionAnnotation(bf.EngineOption)), "engine annotation should be listed")
+	utils.Assert(t, !strings.Contains(f.Annotations[taskAPIsFeature], "Exec"), "exec is not implemented")
+}
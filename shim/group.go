@@ -0,0 +1,22 @@
+package shim
+
+// Annotations which put a container in the same shim as other containers. The
+// runc group annotation is honoured too, so that tooling written for runc
+// groups bf containers the same way. The containers of a Kubernetes pod share
+// the shim of their pod sandbox.
+var groupAnnotations = []string{
+	"io.containerd.bf.v1.group",
+	"io.containerd.runc.v2.group",
+	criSandboxIDAnnotation,
+}
+
+// The group of a container, which names the socket of its shim. Containers
+// which are not in a group get a shim of their own.
+func shimGroup(id string, annotations map[string]string) string {
+	for _, annotation := range groupAnnotations {
+		if group, ok := annotations[annotation]; ok && group != "" {
+			return group
+		}
+	}
+	return id
+}
@@ -0,0 +1,45 @@
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+)
+
+func TestShimGroup(t *testing.T) {
+	utils.AssertEqual(t, shimGroup("task", nil), "task")
+	utils.AssertEqual(t, shimGroup("task", map[string]string{
+		"io.kubernetes.cri.sandbox-id": "pod",
+	}), "pod")
+}
+
+func TestShimGroup_GroupAnnotation(t *testing.T) {
+	utils.AssertEqual(t, shimGroup("task", map[string]string{
+		"io.containerd.bf.v1.group":    "jobs",
+		"io.kubernetes.cri.sandbox-id": "pod",
+	}), "jobs")
+	utils.AssertEqual(t, shimGroup("task", map[string]string{
+		"io.containerd.runc.v2.group": "jobs",
+	}), "jobs")
+}
+
+func TestShutdown_LastTask(t *testing.T) {
+	ctx, sd := shutdown.WithShutdown(context.Background())
+	s := &bfTaskService{
+		procs:    map[string]*proc{"a": {}, "b": {}},
+		shutdown: sd,
+	}
+
+	delete(s.procs, "a")
+	_, err := s.Shutdown(ctx, &taskAPI.ShutdownRequest{ID: "a"})
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, ctx.Err())
+
+	delete(s.procs, "b")
+	_, err = s.Shutdown(ctx, &taskAPI.ShutdownRequest{ID: "b"})
+	utils.AssertNoError(t, err)
+	<-sd.Done()
+}
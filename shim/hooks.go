@@ -0,0 +1,73 @@
+package shim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// The OCI state of the task, as passed to its hooks
+func (p *proc) ociState(id string, status specs.ContainerState) *specs.State {
+	return &specs.State{
+		Version:     specs.Version,
+		ID:          id,
+		Status:      status,
+		Pid:         p.pid,
+		Bundle:      p.bundle,
+		Annotations: p.annotations,
+	}
+}
+
+// Run the hooks of one lifecycle stage in order, passing each of them the
+// state of the container on stdin. Stops at the first hook which fails.
+func runHooks(ctx context.Context, hooks []specs.Hook, state *specs.State) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if err := runHook(ctx, hook, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook specs.Hook, state []byte) error {
+	if hook.Timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*hook.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	// Like execve(2), args[0] is the name of the hook and not necessarily its path
+	cmd := exec.CommandContext(ctx, hook.Path)
+	if len(hook.Args) > 0 {
+		cmd.Args = hook.Args
+	}
+	// Never nil, or the hook would inherit the environment of the shim
+	cmd.Env = append([]string{}, hook.Env...)
+	cmd.Stdin = bytes.NewReader(state)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Don't hang on to the output of a killed hook's children
+	cmd.WaitDelay = command_wait_delay
+
+	if err := cmd.Run(); err != nil {
+		if hook.Timeout != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %ds", *hook.Timeout)
+		}
+		return fmt.Errorf("running hook %s: %w: %s", hook.Path, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestRunHooks_State(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "state.json")
+	hooks := []specs.Hook{{
+		Path: "/bin/sh",
+		Args: []string{"sh", "-c", "cat > " + out},
+	}}
+	state := &specs.State{Version: specs.Version, ID: "task", Status: specs.StateCreating, Pid: 42, Bundle: "/bundle"}
+	utils.AssertNoError(t, runHooks(context.Background(), hooks, state))
+
+	data, err := os.ReadFile(out)
+	utils.AssertNoError(t, err)
+	var read specs.State
+	utils.AssertNoError(t, json.Unmarshal(data, &read))
+	utils.AssertEqual(t, read.ID, "task")
+	utils.AssertEqual(t, read.Pid, 42)
+	utils.AssertEqual(t, read.Status, specs.StateCreating)
+}
+
+func TestRunHooks_StopsAtFailure(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "ran")
+	hooks := []specs.Hook{
+		{Path: "/bin/sh", Args: []string{"sh", "-c", "exit 1"}},
+		{Path: "/bin/sh", Args: []string{"sh", "-c", "touch " + out}},
+	}
+	utils.AssertError(t, runHooks(context.Background(), hooks, &specs.State{}))
+	_, err := os.Stat(out)
+	utils.Assert(t, os.IsNotExist(err), "expected the second hook not to run")
+}
+
+func TestRunHooks_Timeout(t *testing.T) {
+	timeout := 1
+	hooks := []specs.Hook{{Path: "/bin/sh", Args: []string{"sh", "-c", "sleep 10"}, Timeout: &timeout}}
+	utils.AssertError(t, runHooks(context.Background(), hooks, &specs.State{}))
+}
+
+func TestRunHooks_EmptyEnv(t *testing.T) {
+	t.Setenv("BF_HOOK_TEST", "leaked")
+	out := filepath.Join(t.TempDir(), "env")
+	hooks := []specs.Hook{{Path: "/bin/sh", Args: []string{"sh", "-c", "env > " + out}}}
+	utils.AssertNoError(t, runHooks(context.Background(), hooks, &specs.State{}))
+
+	data, err := os.ReadFile(out)
+	utils.AssertNoError(t, err)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// The shell itself sets PWD
+		if line != "" && !strings.HasPrefix(line, "PWD=") {
+			t.Errorf("unexpected variable in the environment of the hook: %s", line)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/fifo"
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+func openFifo(ctx context.Context, path string, flag int) (io.ReadWriteCloser, error) {
+	ok, err := fifo.IsFifo(path)
+	if err != nil {
+		return nil, fmt.Errorf("checking whether file %s is a fifo: %w", path, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("file %s is not a fifo", path)
+	}
+	f, err := fifo.OpenFifo(ctx, path, flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening fifo %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Open the destination of an output stream. The path is either empty (the
+// output is discarded, and nil is returned), a path to a fifo, or a file://
+// URI of a log file.
+func openOutput(ctx context.Context, path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing stdio uri %s: %w", path, err)
+	}
+
+	switch u.Scheme {
+	case "", "fifo":
+		return openFifo(ctx, u.Path, syscall.O_WRONLY)
+	case "file":
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0755); err != nil {
+			return nil, fmt.Errorf("creating log directory: %w", err)
+		}
+		f, err := os.OpenFile(u.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening log file %s: %w", u.Path, err)
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unsupported stdio uri scheme %q", u.Scheme)
+	}
+}
+
+// Open the destinations of the stdout and stderr streams. A binary:// URI in
+// stdout starts a logging binary which receives both of the streams. Either of
+// the returned writers is nil if the corresponding stream is not wanted.
+func openOutputs(ctx context.Context, id, stdout, stderr string) (io.WriteCloser, io.WriteCloser, error) {
+	if u, err := url.Parse(stdout); err == nil && u.Scheme == "binary" {
+		return startLogger(ctx, id, u)
+	}
+
+	fw, err := openOutput(ctx, stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	fe, err := openOutput(ctx, stderr)
+	if err != nil {
+		if fw != nil {
+			fw.Close()
+		}
+		return nil, nil, err
+	}
+	return fw, fe, nil
+}
+
+// Start a logging binary, as described in
+// https://github.com/containerd/containerd/blob/main/core/runtime/v2/README.md#logging
+// The binary receives stdout on fd 3, stderr on fd 4, and signals that it is
+// ready by closing fd 5. Arguments are taken from the query of the URI.
+func startLogger(ctx context.Context, id string, u *url.URL) (io.WriteCloser, io.WriteCloser, error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var args []string
+	for k, vs := range u.Query() {
+		args = append(args, k)
+		if len(vs) > 0 {
+			args = append(args, vs[0])
+		}
+	}
+
+	out_r, out_w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating stdout pipe: %w", err)
+	}
+	err_r, err_w, err := os.Pipe()
+	if err != nil {
+		out_r.Close()
+		out_w.Close()
+		return nil, nil, fmt.Errorf("creating stderr pipe: %w", err)
+	}
+	ready_r, ready_w, err := os.Pipe()
+	if err != nil {
+		out_r.Close()
+		out_w.Close()
+		err_r.Close()
+		err_w.Close()
+		return nil, nil, fmt.Errorf("creating ready pipe: %w", err)
+	}
+	defer ready_r.Close()
+
+	cmd := exec.Command(u.Path, args...)
+	cmd.Env = append(os.Environ(),
+		"CONTAINER_ID="+id,
+		"CONTAINER_NAMESPACE="+ns,
+	)
+	cmd.ExtraFiles = []*os.File{out_r, err_r, ready_w}
+
+	err = cmd.Start()
+
+	// The read ends and the ready pipe belong to the logger now
+	out_r.Close()
+	err_r.Close()
+	ready_w.Close()
+
+	if err != nil {
+		out_w.Close()
+		err_w.Close()
+		return nil, nil, fmt.Errorf("starting logging binary %s: %w", u.Path, err)
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.G(ctx).WithError(err).Warnf("logging binary %s exited", u.Path)
+		}
+	}()
+
+	// Wait for the logger to be ready
+	b := make([]byte, 1)
+	if _, err := ready_r.Read(b); err != nil && err != io.EOF {
+		out_w.Close()
+		err_w.Close()
+		return nil, nil, fmt.Errorf("waiting for logging binary %s: %w", u.Path, err)
+	}
+
+	return out_w, err_w, nil
+}
+
+// Connect the stdio of the command to the given destinations with plain pipes.
+// Without a destination of its own, stderr goes wherever stdout goes. Returns
+// the write end of the stdin pipe of the command and the stdin fifo, or nils if
+// there is no stdin. The caller closes the fifo when the task goes away.
+func setupPipes(ctx context.Context, cmd *exec.Cmd, id, stdin, stdout, stderr string) (_ io.WriteCloser, _ io.Closer, retErr error) {
+	if stderr == "" {
+		stderr = stdout
+	}
+	fw, fe, err := openOutputs(ctx, id, stdout, stderr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fr io.ReadCloser
+	defer func() {
+		if retErr != nil {
+			for _, c := range []io.Closer{fw, fe, fr} {
+				if c != nil {
+					c.Close()
+				}
+			}
+		}
+	}()
+
+	// Set up everything which can fail before starting any of the copies
+	var stdout_pipe, stderr_pipe io.ReadCloser
+	if fw != nil {
+		if stdout_pipe, err = cmd.StdoutPipe(); err != nil {
+			return nil, nil, fmt.Errorf("getting stdout pipe: %w", err)
+		}
+	}
+	if fe != nil {
+		if stderr_pipe, err = cmd.StderrPipe(); err != nil {
+			return nil, nil, fmt.Errorf("getting stderr pipe: %w", err)
+		}
+	}
+	var stdin_pipe io.WriteCloser
+	if stdin != "" {
+		if fr, err = openFifo(ctx, stdin, syscall.O_RDONLY); err != nil {
+			return nil, nil, err
+		}
+		if stdin_pipe, err = cmd.StdinPipe(); err != nil {
+			return nil, nil, fmt.Errorf("getting stdin pipe: %w", err)
+		}
+	}
+
+	// STDOUT
+	if fw != nil {
+		go func() {
+			if _, err := io.Copy(fw, stdout_pipe); err != nil {
+				log.G(ctx).WithError(err).Errorf("failed to copy stdout pipe to %s", stdout)
+			}
+			fw.Close()
+		}()
+	}
+
+	// STDERR
+	if fe != nil {
+		go func() {
+			if _, err := io.Copy(fe, stderr_pipe); err != nil {
+				log.G(ctx).WithError(err).Errorf("failed to copy stderr pipe to %s", stderr)
+			}
+			fe.Close()
+		}()
+	}
+
+	// STDIN
+	if fr != nil {
+		go func() {
+			if _, err := io.Copy(stdin_pipe, fr); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.G(ctx).WithError(err).Errorf("failed to copy fifo %s to stdin pipe", stdin)
+			}
+			// Propagate EOF on the fifo to the process
+			stdin_pipe.Close()
+			fr.Close()
+		}()
+	}
+
+	if fr == nil {
+		return stdin_pipe, nil, nil
+	}
+	return stdin_pipe, fr, nil
+}
+
+// Connect the stdio of the command to a new pty, and the master end of the pty
+// to the given destinations. Returns the master and the slave end of the pty,
+// and the stdin fifo (nil if there is no stdin). The caller is responsible for
+// closing the slave once the command has started, and the fifo when the task
+// goes away.
+func setupTerminal(ctx context.Context, cmd *exec.Cmd, id, stdin, stdout string) (console.Console, *os.File, io.Closer, error) {
+	pty, slave_path, err := console.NewPty()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("creating pty: %w", err)
+	}
+
+	slave, err := os.OpenFile(slave_path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, nil, fmt.Errorf("opening pty slave %s: %w", slave_path, err)
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+
+	// STDOUT (and STDERR, since they share the terminal)
+	fw, fe, err := openOutputs(ctx, id, stdout, "")
+	if err != nil {
+		slave.Close()
+		pty.Close()
+		return nil, nil, nil, err
+	}
+	if fe != nil {
+		// binary logger. there is no separate stderr
+		fe.Close()
+	}
+
+	if fw != nil {
+		go func() {
+			// Reading from the master fails with EIO once the slave is closed by
+			// the exiting process, so we don't report errors here
+			io.Copy(fw, pty)
+			fw.Close()
+		}()
+	}
+
+	// STDIN
+	if stdin == "" {
+		return pty, slave, nil, nil
+	}
+	fr, err := openFifo(ctx, stdin, syscall.O_RDONLY)
+	if err != nil {
+		slave.Close()
+		pty.Close()
+		return nil, nil, nil, err
+	}
+	go func() {
+		if _, err := io.Copy(pty, fr); err != nil && !errors.Is(err, os.ErrClosed) {
+			log.G(ctx).WithError(err).Errorf("failed to copy fifo %s to pty", stdin)
+		}
+		fr.Close()
+	}()
+
+	return pty, slave, fr, nil
+}
+
+// Make the pending read of the process from its terminal return EOF, the same
+// as a user typing the EOF character (^D). Like for the user, a line which has
+// been typed only partly is passed on first, and it takes another EOF to end
+// the input after it.
+func sendEOF(pty console.Console) error {
+	termios, err := unix.IoctlGetTermios(int(pty.Fd()), unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("getting terminal attributes: %w", err)
+	}
+	if _, err := pty.Write([]byte{termios.Cc[unix.VEOF]}); err != nil {
+		return fmt.Errorf("writing EOF to terminal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package shim
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+)
+
+func TestOpenOutput(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular")
+	utils.AssertNoError(t, os.WriteFile(regular, nil, 0644))
+	fifo_path := filepath.Join(dir, "fifo")
+	utils.AssertNoError(t, syscall.Mkfifo(fifo_path, 0600))
+	// Keep a reader on the fifo, so that opening it for writing doesn't block
+	reader, err := os.OpenFile(fifo_path, os.O_RDWR, 0)
+	utils.AssertNoError(t, err)
+	defer reader.Close()
+
+	tests := []struct {
+		name string
+		path string
+		// Whether a writer is expected, and whether opening it fails
+		writer bool
+		fails  bool
+	}{
+		{"empty", "", false, false},
+		{"fifo path", fifo_path, true, false},
+		{"fifo uri", "fifo://" + fifo_path, true, false},
+		{"not a fifo", regular, false, true},
+		{"missing fifo", filepath.Join(dir, "missing"), false, true},
+		{"file uri", "file://" + filepath.Join(dir, "logs", "out.log"), true, false},
+		{"unsupported scheme", "tcp://localhost:1234", false, true},
+		{"invalid uri", "file://%zz", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := openOutput(context.Background(), test.path)
+			if test.fails {
+				utils.AssertError(t, err)
+				return
+			}
+			utils.AssertNoError(t, err)
+			utils.AssertEqual(t, w != nil, test.writer)
+			if w != nil {
+				w.Close()
+			}
+		})
+	}
+}
+
+func TestOpenOutputs_File(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.log")
+	// The file is appended to, never truncated
+	utils.AssertNoError(t, os.WriteFile(out, []byte("old\n"), 0644))
+
+	fw, fe, err := openOutputs(context.Background(), "task", "file://"+out, "")
+	utils.AssertNoError(t, err)
+	utils.Assert(t, fe == nil, "expected no stderr writer")
+	_, err = io.WriteString(fw, "new\n")
+	utils.AssertNoError(t, err)
+	fw.Close()
+
+	data, err := os.ReadFile(out)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, string(data), "old\nnew\n")
+}
+
+func TestSetupPipes_StderrFallback(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.log")
+	cmd := exec.Command("/bin/sh", "-c", "echo out; echo err >&2")
+	_, _, err := setupPipes(context.Background(), cmd, "task", "", "file://"+out, "")
+	utils.AssertNoError(t, err)
+	utils.AssertNoError(t, cmd.Start())
+	// Wait closes the pipes, so only call it once the copies are done
+	defer cmd.Wait()
+
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(out)
+		return strings.Contains(string(data), "out\n") && strings.Contains(string(data), "err\n")
+	})
+}
+
+func TestSetupPipes_MissingStdin(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	_, _, err := setupPipes(context.Background(), cmd, "task", filepath.Join(t.TempDir(), "missing"), "", "")
+	utils.AssertError(t, err)
+}
+
+func TestOpenOutputs_LoggerNeedsNamespace(t *testing.T) {
+	_, _, err := openOutputs(context.Background(), "task", "binary:///bin/true", "")
+	utils.AssertError(t, err)
+}
+
+const loggerEnv = "BF_TEST_LOGGER_DIR"
+
+// The logging binary is the test binary itself. It writes what it gets into
+// files in the directory named by loggerEnv.
+func TestStartLogger(t *testing.T) {
+	if dir := os.Getenv(loggerEnv); dir != "" {
+		runTestLogger(dir)
+		os.Exit(0)
+	}
+
+	dir := t.TempDir()
+	t.Setenv(loggerEnv, dir)
+	u := &url.URL{
+		Scheme:   "binary",
+		Path:     os.Args[0],
+		RawQuery: url.Values{"-test.run": {"^TestStartLogger$"}}.Encode(),
+	}
+	ctx := namespaces.WithNamespace(context.Background(), "testns")
+	fw, fe, err := openOutputs(ctx, "task", u.String(), "")
+	utils.AssertNoError(t, err)
+	io.WriteString(fw, "to stdout")
+	io.WriteString(fe, "to stderr")
+	fw.Close()
+	fe.Close()
+
+	waitFor(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "done"))
+		return err == nil
+	})
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		utils.AssertNoError(t, err)
+		return string(data)
+	}
+	utils.AssertEqual(t, read("stdout"), "to stdout")
+	utils.AssertEqual(t, read("stderr"), "to stderr")
+	env := read("env")
+	utils.Assert(t, strings.Contains(env, "CONTAINER_ID=task\n"), "expected CONTAINER_ID")
+	utils.Assert(t, strings.Contains(env, "CONTAINER_NAMESPACE=testns\n"), "expected CONTAINER_NAMESPACE")
+	// The logger inherits the environment of the shim
+	utils.Assert(t, strings.Contains(env, loggerEnv+"="), "expected the environment of the shim")
+}
+
+func runTestLogger(dir string) {
+	stdout := os.NewFile(3, "stdout")
+	stderr := os.NewFile(4, "stderr")
+	ready := os.NewFile(5, "ready")
+	ready.Close()
+
+	out, _ := io.ReadAll(stdout)
+	errs, _ := io.ReadAll(stderr)
+	os.WriteFile(filepath.Join(dir, "stdout"), out, 0644)
+	os.WriteFile(filepath.Join(dir, "stderr"), errs, 0644)
+	os.WriteFile(filepath.Join(dir, "env"), []byte(strings.Join(os.Environ(), "\n")+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "done"), nil, 0644)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
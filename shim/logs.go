@@ -0,0 +1,71 @@
+package shim
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+
+	"github.com/containerd/log"
+)
+
+// The interpreter process writes its logs to this file descriptor (the first
+// of cmd.ExtraFiles)
+const interpreterLogFd = 3
+
+// Log level of the interpreter process, matching the level of the shim (which
+// is debug when containerd starts the shim with -debug)
+func interpreterLogLevel() slog.Level {
+	switch log.GetLevel() {
+	case log.TraceLevel, log.DebugLevel:
+		return slog.LevelDebug
+	case log.InfoLevel:
+		return slog.LevelInfo
+	case log.WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// Forward the json logs of the interpreter process to the log of the shim, so
+// that they end up in containerd's log tagged with the task id
+func forwardLogs(ctx context.Context, id string, pid int, r io.ReadCloser) {
+	defer r.Close()
+	entry := log.G(ctx).WithField("id", id).WithField("pid", pid)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		forwardLog(entry, scanner.Bytes())
+	}
+}
+
+func forwardLog(entry *log.Entry, line []byte) {
+	var record map[string]any
+	if err := json.Unmarshal(line, &record); err != nil {
+		entry.Info(string(line))
+		return
+	}
+
+	msg, _ := record[slog.MessageKey].(string)
+	level := slog.LevelInfo
+	if s, ok := record[slog.LevelKey].(string); ok {
+		level.UnmarshalText([]byte(s))
+	}
+	// the shim adds its own time
+	delete(record, slog.TimeKey)
+	delete(record, slog.MessageKey)
+	delete(record, slog.LevelKey)
+
+	entry = entry.WithFields(log.Fields(record))
+	switch {
+	case level >= slog.LevelError:
+		entry.Error(msg)
+	case level >= slog.LevelWarn:
+		entry.Warn(msg)
+	case level >= slog.LevelInfo:
+		entry.Info(msg)
+	default:
+		entry.Debug(msg)
+	}
+}
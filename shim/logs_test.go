@@ -0,0 +1,48 @@
+package shim
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	"github.com/containerd/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestForwardLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.DebugLevel
+	entry := logrus.NewEntry(logger).WithField("id", "task")
+
+	forwardLog(entry, []byte(`{"time":"2026-01-01T00:00:00Z","level":"WARN","msg":"writing metrics","path":"/x"}`))
+	forwardLog(entry, []byte(`not json`))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	utils.AssertEqual(t, len(lines), 2)
+
+	var record map[string]any
+	utils.AssertNoError(t, json.Unmarshal(lines[0], &record))
+	utils.AssertEqual(t, record["level"], any("warning"))
+	utils.AssertEqual(t, record["msg"], any("writing metrics"))
+	utils.AssertEqual(t, record["id"], any("task"))
+	utils.AssertEqual(t, record["path"], any("/x"))
+	utils.Assert(t, record["time"] != "2026-01-01T00:00:00Z", "the time of the shim should be used")
+
+	utils.AssertNoError(t, json.Unmarshal(lines[1], &record))
+	utils.AssertEqual(t, record["level"], any("info"))
+	utils.AssertEqual(t, record["msg"], any("not json"))
+}
+
+func TestInterpreterLogLevel(t *testing.T) {
+	level := log.GetLevel()
+	defer log.SetLevel(level.String())
+
+	utils.AssertNoError(t, log.SetLevel("debug"))
+	utils.AssertEqual(t, interpreterLogLevel().String(), "DEBUG")
+	utils.AssertNoError(t, log.SetLevel("warn"))
+	utils.AssertEqual(t, interpreterLogLevel().String(), "WARN")
+}
@@ -0,0 +1,93 @@
+package shim
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/shim/types"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/proto"
+)
+
+const metricsFilename = "bf.metrics.pb"
+
+// How often the interpreter process dumps its metrics to the bundle
+const MetricsInterval = 100 * time.Millisecond
+
+// Metrics of a brainfuck task, as returned by the Stats task API, are a
+// types.Metrics message so that clients can decode them with typeurl.
+func NewMetrics(stats bf.Stats, cpuTime time.Duration) *types.Metrics {
+	return &types.Metrics{
+		Instructions:   stats.Instructions,
+		ProgramCounter: stats.ProgramCounter,
+		TapeHighWater:  stats.TapeHighWater,
+		BytesRead:      stats.BytesRead,
+		BytesWritten:   stats.BytesWritten,
+		CpuTimeNs:      uint64(cpuTime),
+	}
+}
+
+func MetricsPath(bundle string) string {
+	return filepath.Join(bundle, interpreterDirname, metricsFilename)
+}
+
+// Open the metrics file for WriteMetrics. The interpreter opens it before
+// applying its sandbox, which does not allow opening files.
+func OpenMetrics(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening metrics file: %w", err)
+	}
+	return f, nil
+}
+
+// Overwrite the metrics in a file opened by OpenMetrics. The file is locked
+// while it is rewritten, so that ReadMetrics never sees a partial write.
+func WriteMetrics(f *os.File, m *types.Metrics) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fd := int(f.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return fmt.Errorf("locking metrics file: %w", err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("writing metrics file: %w", err)
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("writing metrics file: %w", err)
+	}
+	return nil
+}
+
+// Read the metrics from a file. When the file does not exist or is still empty
+// (the interpreter has not started yet) ReadMetrics returns zero metrics
+// without an error, since an empty message is encoded as no bytes.
+func ReadMetrics(path string) (*types.Metrics, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &types.Metrics{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH); err != nil {
+		return nil, fmt.Errorf("locking metrics file: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var m types.Metrics
+	if err := proto.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing metrics file: %w", err)
+	}
+	return &m, nil
+}
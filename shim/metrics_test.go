@@ -0,0 +1,70 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/shim/types"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	"github.com/containerd/typeurl/v2"
+)
+
+func TestMetrics_WriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metricsFilename)
+
+	// Before the interpreter writes anything the metrics are zero
+	m, err := ReadMetrics(path)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, m.Instructions, 0)
+
+	f, err := OpenMetrics(path)
+	utils.AssertNoError(t, err)
+	defer f.Close()
+	utils.AssertNoError(t, WriteMetrics(f, NewMetrics(bf.Stats{Instructions: 1000, BytesWritten: 12}, time.Second)))
+	utils.AssertNoError(t, WriteMetrics(f, NewMetrics(bf.Stats{Instructions: 5}, time.Millisecond)))
+
+	m, err = ReadMetrics(path)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, m.Instructions, 5)
+	utils.AssertEqual(t, m.BytesWritten, 0)
+	utils.AssertEqual(t, m.CpuTimeNs, uint64(time.Millisecond))
+}
+
+func TestStatsAndPids_DecodeWithTypeurl(t *testing.T) {
+	bundle := t.TempDir()
+	utils.AssertNoError(t, writeTaskState(bundle, &taskState{ID: "task", Pid: 1234, Status: "stopped"}))
+	utils.AssertNoError(t, os.MkdirAll(filepath.Join(bundle, interpreterDirname), 0755))
+	f, err := OpenMetrics(MetricsPath(bundle))
+	utils.AssertNoError(t, err)
+	defer f.Close()
+	utils.AssertNoError(t, WriteMetrics(f, NewMetrics(bf.Stats{Instructions: 42}, 0)))
+
+	t.Chdir(bundle)
+	ctx, sd := shutdown.WithShutdown(context.Background())
+	defer sd.Shutdown()
+	service, err := newTaskService(ctx, sd)
+	utils.AssertNoError(t, err)
+
+	stats, err := service.Stats(ctx, &taskAPI.StatsRequest{ID: "task"})
+	utils.AssertNoError(t, err)
+	v, err := typeurl.UnmarshalAny(stats.Stats)
+	utils.AssertNoError(t, err)
+	metrics, ok := v.(*types.Metrics)
+	utils.Assert(t, ok, "expected stats to decode to *types.Metrics")
+	utils.AssertEqual(t, metrics.Instructions, 42)
+
+	pids, err := service.Pids(ctx, &taskAPI.PidsRequest{ID: "task"})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, len(pids.Processes), 1)
+	v, err = typeurl.UnmarshalAny(pids.Processes[0].Info)
+	utils.AssertNoError(t, err)
+	details, ok := v.(*types.ProcessDetails)
+	utils.Assert(t, ok, "expected process info to decode to *types.ProcessDetails")
+	utils.AssertEqual(t, details.Status, "stopped")
+}
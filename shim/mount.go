@@ -0,0 +1,44 @@
+package shim
+
+import (
+	"fmt"
+	"os"
+
+	apitypes "github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/v2/core/mount"
+)
+
+// Mount the rootfs given in the create request at the root path of the
+// bundle config. Docker mounts the rootfs itself and passes no mounts, in which
+// case nothing is done and an empty path is returned. Otherwise returns the
+// path of the mounted rootfs.
+func mountRootfs(rootfs string, mounts []*apitypes.Mount) (string, error) {
+	if len(mounts) == 0 {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(rootfs, 0711); err != nil {
+		return "", fmt.Errorf("creating rootfs directory: %w", err)
+	}
+
+	if err := mount.All(mount.FromProto(mounts), rootfs); err != nil {
+		// Some of the mounts might have succeeded
+		if uerr := unmountRootfs(rootfs); uerr != nil {
+			return "", fmt.Errorf("mounting rootfs: %w (and unmounting it: %v)", err, uerr)
+		}
+		return "", fmt.Errorf("mounting rootfs: %w", err)
+	}
+
+	return rootfs, nil
+}
+
+// Unmount everything mounted at (and under) the rootfs
+func unmountRootfs(rootfs string) error {
+	if rootfs == "" {
+		return nil
+	}
+	if err := mount.UnmountRecursive(rootfs, 0); err != nil {
+		return fmt.Errorf("unmounting rootfs %s: %w", rootfs, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package shim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	apitypes "github.com/containerd/containerd/api/types"
+)
+
+func TestMountRootfs_NoMounts(t *testing.T) {
+	rootfs, err := mountRootfs(t.TempDir(), nil)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, rootfs, "")
+}
+
+func TestMountRootfs_Bind(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+
+	source := t.TempDir()
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(source, "hello.bf"), []byte("+."), 0644))
+
+	root := filepath.Join(t.TempDir(), "custom-root")
+	rootfs, err := mountRootfs(root, []*apitypes.Mount{{
+		Type:    "bind",
+		Source:  source,
+		Options: []string{"rbind", "ro"},
+	}})
+	if err != nil {
+		t.Skipf("bind mounts not permitted: %v", err)
+	}
+	utils.AssertEqual(t, rootfs, root)
+
+	_, err = os.Stat(filepath.Join(rootfs, "hello.bf"))
+	utils.AssertNoError(t, err)
+
+	utils.AssertNoError(t, unmountRootfs(rootfs))
+	_, err = os.Stat(filepath.Join(rootfs, "hello.bf"))
+	utils.Assert(t, os.IsNotExist(err), "rootfs still mounted")
+}
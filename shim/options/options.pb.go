@@ -0,0 +1,168 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: shim/options/options.proto
+
+package options
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Runtime options of the bf shim. containerd passes them to the shim when it
+// creates a task, so they set the interpreter defaults of a runtime handler.
+// Unset (zero) fields keep the interpreter defaults.
+type Options struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Width of a memory cell in bits: 8, 16 or 32
+	CellWidth uint32 `protobuf:"varint,1,opt,name=cell_width,json=cellWidth,proto3" json:"cell_width,omitempty"`
+	// Number of cells on the tape. Containers can ask for fewer, not more.
+	TapeSize uint32 `protobuf:"varint,2,opt,name=tape_size,json=tapeSize,proto3" json:"tape_size,omitempty"`
+	// What `,` does at the end of the input: stop, zero, max or unchanged
+	EofMode string `protobuf:"bytes,3,opt,name=eof_mode,json=eofMode,proto3" json:"eof_mode,omitempty"`
+	// Engine which runs the program: basic, fast, optimized or compiled
+	Engine string `protobuf:"bytes,4,opt,name=engine,proto3" json:"engine,omitempty"`
+	// Maximum number of instructions a program may execute. Containers can
+	// ask for a lower limit, not a higher one or none.
+	MaxSteps      uint64 `protobuf:"varint,5,opt,name=max_steps,json=maxSteps,proto3" json:"max_steps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Options) Reset() {
+	*x = Options{}
+	mi := &file_shim_options_options_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Options) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Options) ProtoMessage() {}
+
+func (x *Options) ProtoReflect() protoreflect.Message {
+	mi := &file_shim_options_options_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Options.ProtoReflect.Descriptor instead.
+func (*Options) Descriptor() ([]byte, []int) {
+	return file_shim_options_options_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Options) GetCellWidth() uint32 {
+	if x != nil {
+		return x.CellWidth
+	}
+	return 0
+}
+
+func (x *Options) GetTapeSize() uint32 {
+	if x != nil {
+		return x.TapeSize
+	}
+	return 0
+}
+
+func (x *Options) GetEofMode() string {
+	if x != nil {
+		return x.EofMode
+	}
+	return ""
+}
+
+func (x *Options) GetEngine() string {
+	if x != nil {
+		return x.Engine
+	}
+	return ""
+}
+
+func (x *Options) GetMaxSteps() uint64 {
+	if x != nil {
+		return x.MaxSteps
+	}
+	return 0
+}
+
+var File_shim_options_options_proto protoreflect.FileDescriptor
+
+const file_shim_options_options_proto_rawDesc = "" +
+	"\n" +
+	"\x1ashim/options/options.proto\x12\x10containerd.bf.v1\"\x95\x01\n" +
+	"\aOptions\x12\x1d\n" +
+	"\n" +
+	"cell_width\x18\x01 \x01(\rR\tcellWidth\x12\x1b\n" +
+	"\ttape_size\x18\x02 \x01(\rR\btapeSize\x12\x19\n" +
+	"\beof_mode\x18\x03 \x01(\tR\aeofMode\x12\x16\n" +
+	"\x06engine\x18\x04 \x01(\tR\x06engine\x12\x1b\n" +
+	"\tmax_steps\x18\x05 \x01(\x04R\bmaxStepsB9Z7github.com/MarcinKonowalczyk/runbf/shim/options;optionsb\x06proto3"
+
+var (
+	file_shim_options_options_proto_rawDescOnce sync.Once
+	file_shim_options_options_proto_rawDescData []byte
+)
+
+func file_shim_options_options_proto_rawDescGZIP() []byte {
+	file_shim_options_options_proto_rawDescOnce.Do(func() {
+		file_shim_options_options_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_shim_options_options_proto_rawDesc), len(file_shim_options_options_proto_rawDesc)))
+	})
+	return file_shim_options_options_proto_rawDescData
+}
+
+var file_shim_options_options_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_shim_options_options_proto_goTypes = []any{
+	(*Options)(nil), // 0: containerd.bf.v1.Options
+}
+var file_shim_options_options_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_shim_options_options_proto_init() }
+func file_shim_options_options_proto_init() {
+	if File_shim_options_options_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_shim_options_options_proto_rawDesc), len(file_shim_options_options_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_shim_options_options_proto_goTypes,
+		DependencyIndexes: file_shim_options_options_proto_depIdxs,
+		MessageInfos:      file_shim_options_options_proto_msgTypes,
+	}.Build()
+	File_shim_options_options_proto = out.File
+	file_shim_options_options_proto_goTypes = nil
+	file_shim_options_options_proto_depIdxs = nil
+}
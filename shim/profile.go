@@ -0,0 +1,32 @@
+package shim
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+)
+
+const profileFilename = "bf.profile.pb.gz"
+
+// Annotation which makes the interpreter write a pprof profile of the program
+// into the bundle when it exits
+const profileAnnotation = bf.AnnotationPrefix + "profile"
+
+func ProfilePath(bundle string) string {
+	return filepath.Join(bundle, interpreterDirname, profileFilename)
+}
+
+// Whether the annotations ask for a profile
+func profileEnabled(annotations map[string]string) (bool, error) {
+	value, ok := annotations[profileAnnotation]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("annotation %s: %w", profileAnnotation, err)
+	}
+	return enabled, nil
+}
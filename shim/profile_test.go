@@ -0,0 +1,20 @@
+package shim
+
+import (
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+)
+
+func TestProfileEnabled(t *testing.T) {
+	enabled, err := profileEnabled(nil)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, enabled, false)
+
+	enabled, err = profileEnabled(map[string]string{"io.containerd.bf.v1.profile": "true"})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, enabled, true)
+
+	_, err = profileEnabled(map[string]string{"io.containerd.bf.v1.profile": "yes please"})
+	utils.AssertError(t, err)
+}
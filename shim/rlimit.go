@@ -0,0 +1,45 @@
+package shim
+
+import (
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Resources which can be limited with process.rlimits
+var rlimitResources = map[string]int{
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+}
+
+// Set the rlimits of a running process. Like the cgroup, this is done while
+// the init process waits to be started, so the program runs with them from its
+// first instruction.
+func setRlimits(pid int, rlimits []specs.POSIXRlimit) error {
+	for _, rlimit := range rlimits {
+		resource, ok := rlimitResources[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit %s", rlimit.Type)
+		}
+		limit := unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}
+		if err := unix.Prlimit(pid, resource, &limit, nil); err != nil {
+			return fmt.Errorf("setting %s of process %d: %w", rlimit.Type, pid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package shim
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetRlimits(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	utils.AssertNoError(t, cmd.Start())
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	err := setRlimits(cmd.Process.Pid, []specs.POSIXRlimit{
+		{Type: "RLIMIT_CPU", Soft: 1, Hard: 2},
+	})
+	utils.AssertNoError(t, err)
+
+	var limit unix.Rlimit
+	utils.AssertNoError(t, unix.Prlimit(cmd.Process.Pid, unix.RLIMIT_CPU, nil, &limit))
+	utils.AssertEqual(t, limit.Cur, 1)
+	utils.AssertEqual(t, limit.Max, 2)
+}
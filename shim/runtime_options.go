@@ -0,0 +1,120 @@
+package shim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/shim/options"
+	runtimeoptions "github.com/containerd/containerd/api/types/runtimeoptions/v1"
+	"github.com/containerd/typeurl/v2"
+	"github.com/pelletier/go-toml/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Resolve the interpreter options of a task. The runtime options of the
+// runtime handler are applied over the defaults, then the BF_* variables of
+// the process environment and then the annotations of the container (see
+// bf.Options for the whole order).
+//
+// The max_steps and tape_size of the runtime options are limits of the
+// handler: the image and the container may lower them, but not raise or
+// remove them.
+func interpreterOptions(runtime_options *anypb.Any, env []string, annotations map[string]string) (bf.Options, error) {
+	opts := bf.DefaultOptions()
+
+	ropts, err := decodeRuntimeOptions(runtime_options)
+	if err != nil {
+		return opts, fmt.Errorf("decoding runtime options: %w", err)
+	}
+	if ropts != nil {
+		if ropts.CellWidth != 0 {
+			opts.CellWidth = int(ropts.CellWidth)
+		}
+		if ropts.TapeSize != 0 {
+			opts.TapeSize = int(ropts.TapeSize)
+		}
+		if ropts.EofMode != "" {
+			opts.EOFMode = bf.EOFMode(ropts.EofMode)
+		}
+		if ropts.Engine != "" {
+			opts.Engine = bf.Engine(ropts.Engine)
+		}
+		if ropts.MaxSteps != 0 {
+			opts.MaxSteps = ropts.MaxSteps
+		}
+	}
+
+	if err := opts.ApplyEnv(env); err != nil {
+		return opts, err
+	}
+	if err := opts.ApplyAnnotations(annotations); err != nil {
+		return opts, err
+	}
+
+	if ropts != nil {
+		if ropts.MaxSteps != 0 && (opts.MaxSteps == 0 || opts.MaxSteps > ropts.MaxSteps) {
+			opts.MaxSteps = ropts.MaxSteps
+		}
+		if ropts.TapeSize != 0 && opts.TapeSize > int(ropts.TapeSize) {
+			opts.TapeSize = int(ropts.TapeSize)
+		}
+	}
+
+	return opts, opts.Validate()
+}
+
+// Decode the options of CreateTaskRequest. Go clients can send the bf options
+// type directly. The CRI plugin of containerd sends the generic runtime options
+// instead, with the options table of the runtime handler as a TOML blob (or a
+// path to a file with it).
+func decodeRuntimeOptions(any *anypb.Any) (*options.Options, error) {
+	if any == nil || any.GetTypeUrl() == "" {
+		return nil, nil
+	}
+	v, err := typeurl.UnmarshalAny(any)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case *options.Options:
+		return v, nil
+	case *runtimeoptions.Options:
+		body := v.ConfigBody
+		if v.ConfigPath != "" {
+			if body, err = os.ReadFile(v.ConfigPath); err != nil {
+				return nil, err
+			}
+		}
+		return parseOptionsTOML(body)
+	default:
+		return nil, fmt.Errorf("unsupported runtime options type %s", any.GetTypeUrl())
+	}
+}
+
+// Parse runtime options from the TOML options table of a runtime handler, such
+// as
+//
+//	cell_width = 16
+//	eof_mode = "zero"
+//
+// Keys which aren't bf options are ignored, since the table may hold settings
+// for other parts of containerd.
+func parseOptionsTOML(data []byte) (*options.Options, error) {
+	fields := map[string]any{}
+	if err := toml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var opts options.Options
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, &opts); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
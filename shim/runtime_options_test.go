@@ -0,0 +1,130 @@
+package shim
+
+import (
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/bf"
+	"github.com/MarcinKonowalczyk/runbf/shim/options"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	runtimeoptions "github.com/containerd/containerd/api/types/runtimeoptions/v1"
+	"github.com/containerd/typeurl/v2"
+)
+
+func TestInterpreterOptions_Defaults(t *testing.T) {
+	opts, err := interpreterOptions(nil, nil, nil)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, opts, bf.DefaultOptions())
+}
+
+func TestInterpreterOptions_Typed(t *testing.T) {
+	any, err := typeurl.MarshalAnyToProto(&options.Options{CellWidth: 16, Engine: "fast"})
+	utils.AssertNoError(t, err)
+
+	opts, err := interpreterOptions(any, nil, map[string]string{
+		bf.OptionAnnotation(bf.EngineOption):   "basic",
+		bf.OptionAnnotation(bf.MaxStepsOption): "1000",
+	})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, opts.CellWidth, 16)
+	utils.AssertEqual(t, opts.Engine, bf.EngineBasic)
+	utils.AssertEqual(t, opts.MaxSteps, 1000)
+	utils.AssertEqual(t, opts.TapeSize, bf.DefaultOptions().TapeSize)
+}
+
+func TestInterpreterOptions_ConfigBody(t *testing.T) {
+	any, err := typeurl.MarshalAnyToProto(&runtimeoptions.Options{
+		ConfigBody: []byte("# strict\ncell_width = 32\neof_mode = \"zero\"\nmax_steps = 500\n"),
+	})
+	utils.AssertNoError(t, err)
+
+	opts, err := interpreterOptions(any, nil, nil)
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, opts.CellWidth, 32)
+	utils.AssertEqual(t, opts.EOFMode, bf.EOFZero)
+	utils.AssertEqual(t, opts.MaxSteps, 500)
+}
+
+func TestInterpreterOptions_Invalid(t *testing.T) {
+	_, err := interpreterOptions(nil, nil, map[string]string{bf.OptionAnnotation(bf.CellWidthOption): "twelve"})
+	utils.AssertError(t, err)
+
+	_, err = interpreterOptions(nil, nil, map[string]string{bf.OptionAnnotation(bf.EOFModeOption): "explode"})
+	utils.AssertError(t, err)
+}
+
+func TestInterpreterOptions_Precedence(t *testing.T) {
+	any, err := typeurl.MarshalAnyToProto(&options.Options{CellWidth: 16, TapeSize: 300, Engine: "fast"})
+	utils.AssertNoError(t, err)
+
+	opts, err := interpreterOptions(any, []string{"PATH=/bin", "BF_TAPE_SIZE=200", "BF_ENGINE=basic"}, map[string]string{
+		bf.OptionAnnotation(bf.EngineOption): "fast",
+	})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, opts.CellWidth, 16)
+	utils.AssertEqual(t, opts.TapeSize, 200)
+	utils.AssertEqual(t, opts.Engine, bf.EngineFast)
+}
+
+func TestParseOptionsTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *options.Options
+	}{
+		{"empty", "", &options.Options{}},
+		{"inline comment", "cell_width = 16 # wide cells\n", &options.Options{CellWidth: 16}},
+		{"literal string", "engine = 'fast'\n", &options.Options{Engine: "fast"}},
+		{"multi-line string", "eof_mode = \"\"\"\nzero\"\"\"\n", &options.Options{EofMode: "zero"}},
+		{"unknown keys", "SystemdCgroup = true\nbinary_name = \"x\"\nmax_steps = 10\n", &options.Options{MaxSteps: 10}},
+		{"unknown array", "extra = [\n  1,\n  2,\n]\ntape_size = 64\n", &options.Options{TapeSize: 64}},
+		{"unknown table", "tape_size = 64\n[extra]\ncell_width = 32\n", &options.Options{TapeSize: 64}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := parseOptionsTOML([]byte(test.data))
+			utils.AssertNoError(t, err)
+			utils.AssertEqual(t, opts.CellWidth, test.want.CellWidth)
+			utils.AssertEqual(t, opts.TapeSize, test.want.TapeSize)
+			utils.AssertEqual(t, opts.EofMode, test.want.EofMode)
+			utils.AssertEqual(t, opts.Engine, test.want.Engine)
+			utils.AssertEqual(t, opts.MaxSteps, test.want.MaxSteps)
+		})
+	}
+}
+
+func TestParseOptionsTOML_Invalid(t *testing.T) {
+	for _, data := range []string{"cell_width = ", "cell_width = \"wide\"\n", "[broken\n"} {
+		_, err := parseOptionsTOML([]byte(data))
+		utils.AssertError(t, err)
+	}
+}
+
+func TestInterpreterOptions_Limits(t *testing.T) {
+	any, err := typeurl.MarshalAnyToProto(&options.Options{TapeSize: 100, MaxSteps: 1000})
+	utils.AssertNoError(t, err)
+
+	tests := []struct {
+		name        string
+		env         []string
+		annotations map[string]string
+		steps       uint64
+		tape        int
+	}{
+		{"unset", nil, nil, 1000, 100},
+		{"lowered", []string{"BF_MAX_STEPS=10"}, map[string]string{bf.OptionAnnotation(bf.TapeSizeOption): "50"}, 10, 50},
+		{"raised by annotation", nil, map[string]string{
+			bf.OptionAnnotation(bf.MaxStepsOption): "5000",
+			bf.OptionAnnotation(bf.TapeSizeOption): "30000",
+		}, 1000, 100},
+		{"removed by annotation", nil, map[string]string{bf.OptionAnnotation(bf.MaxStepsOption): "0"}, 1000, 100},
+		{"removed by env", []string{"BF_MAX_STEPS=0"}, nil, 1000, 100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := interpreterOptions(any, test.env, test.annotations)
+			utils.AssertNoError(t, err)
+			utils.AssertEqual(t, opts.MaxSteps, test.steps)
+			utils.AssertEqual(t, opts.TapeSize, test.tape)
+		})
+	}
+}
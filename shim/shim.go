@@ -2,23 +2,29 @@ package shim
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	"github.com/MarcinKonowalczyk/runbf/sandbox"
+	"github.com/MarcinKonowalczyk/runbf/shim/types"
+
+	"github.com/containerd/console"
 	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
 	apitypes "github.com/containerd/containerd/api/types"
 	tasktypes "github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/protobuf"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
 	ptypes "github.com/containerd/containerd/v2/pkg/protobuf/types"
 	"github.com/containerd/containerd/v2/pkg/shim"
 	"github.com/containerd/containerd/v2/pkg/shutdown"
@@ -26,20 +32,23 @@ import (
 	"github.com/containerd/errdefs"
 	"github.com/containerd/fifo"
 	"github.com/containerd/log"
-	"google.golang.org/protobuf/types/known/anypb"
+	"github.com/containerd/typeurl/v2"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	// registers the typeurls of the runtime-spec types (LinuxResources)
+	_ "github.com/containerd/containerd/v2/core/runtime"
 
 	"github.com/containerd/plugin"
 	"github.com/containerd/plugin/registry"
 	"github.com/containerd/ttrpc"
 )
 
-// https://pubs.opengroup.org/onlinepubs/9699919799/utilities/V3_chap02.html#tag_18_21_18
-const exitCodeSignal = 128
-const initPidFile = "bf.pid"
+// Exit status reported when we don't know how the init process exited
+const unknownExitStatus = 255
 
-// comptime override for debug flag
-// set with `-ldflags="-X 'github.com/MarcinKonowalczyk/runbf/shim.debug=true'"`
-var debug string
+// How often to check whether the init process of a recovered task is alive
+const recoveredPollInterval = 100 * time.Millisecond
+const initPidFile = "bf.pid"
 
 func init() {
 	registry.Register(&plugin.Registration{
@@ -84,16 +93,29 @@ func (m bfManager) Start(ctx context.Context, id string, opts shim.StartOpts) (r
 	}
 
 	var args []string
-	if opts.Debug || debug != "" {
+	if opts.Debug {
 		args = append(args, "-debug")
 	}
 
+	// containerd starts the shim in the bundle directory of the container
+	spec, err := readSpec(cwd)
+	if err != nil {
+		return retShim, fmt.Errorf("reading config file: %w", err)
+	}
+	group := shimGroup(id, spec.Annotations)
+
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return retShim, err
+	}
+
 	cmdCfg := &shim.CommandConfig{
 		Runtime:      self,
 		Address:      opts.Address,
 		TTRPCAddress: opts.TTRPCAddress,
 		Path:         cwd,
 		Args:         args,
+		Env:          []string{stateDirEnv + "=" + shimStateDir(opts.Address, ns, group)},
 	}
 
 	cmd, err := shim.Command(ctx, cmdCfg)
@@ -101,14 +123,33 @@ func (m bfManager) Start(ctx context.Context, id string, opts shim.StartOpts) (r
 		return retShim, fmt.Errorf("creating shim command: %w", err)
 	}
 
-	sockAddr, err := shim.SocketAddress(ctx, opts.Address, id, opts.Debug)
+	sockAddr, err := shim.SocketAddress(ctx, opts.Address, group, opts.Debug)
 	if err != nil {
 		return retShim, fmt.Errorf("getting a socket address: %w", err)
 	}
 
 	socket, err := shim.NewSocket(sockAddr)
 	if err != nil {
-		return retShim, fmt.Errorf("creating socket: %w", err)
+		if !shim.SocketEaddrinuse(err) {
+			return retShim, fmt.Errorf("creating socket: %w", err)
+		}
+		if shim.CanConnect(sockAddr) {
+			// Another container of the group has started the shim already
+			log.G(ctx).Debugf("reusing the shim of group %s", group)
+			retShim = shim.BootstrapParams{
+				Version:  2,
+				Address:  sockAddr,
+				Protocol: "ttrpc",
+			}
+			return retShim, nil
+		}
+		// The socket was left behind by a shim which is gone
+		if err := shim.RemoveSocket(sockAddr); err != nil {
+			return retShim, fmt.Errorf("removing stale socket: %w", err)
+		}
+		if socket, err = shim.NewSocket(sockAddr); err != nil {
+			return retShim, fmt.Errorf("creating socket: %w", err)
+		}
 	}
 
 	sockF, err := socket.File()
@@ -158,35 +199,67 @@ func (m bfManager) Start(ctx context.Context, id string, opts shim.StartOpts) (r
 func (m bfManager) Stop(ctx context.Context, id string) (shim.StopStatus, error) {
 	log.G(ctx).Debug("Stop (manager)")
 
-	pid, err := readPidFile(id)
+	// containerd runs the delete command in the bundle directory
+	bundle, err := os.Getwd()
 	if err != nil {
-		return shim.StopStatus{}, fmt.Errorf("reading pid file: %w", err)
+		return shim.StopStatus{}, fmt.Errorf("getting current working directory: %w", err)
 	}
 
-	if pid > 0 {
-		p, _ := os.FindProcess(pid)
-		// The POSIX standard specifies that a null-signal can be sent to check
-		// whether a PID is valid.
-		if err := p.Signal(syscall.Signal(0)); err == nil {
-			if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
-				log.G(ctx).WithError(err).Warnf("failed to send kill syscall to init process %d", pid)
-			}
+	state, err := readTaskState(bundle)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to read task state, falling back to the pid file")
+		pid, err := readPidFile(bundle)
+		if err != nil {
+			return shim.StopStatus{}, fmt.Errorf("reading pid file: %w", err)
+		}
+		state = &taskState{ID: id, Pid: pid, Status: "running"}
+	}
+
+	// The init process is gone by the time these run
+	defer func() {
+		if err := unmountRootfs(state.Rootfs); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to clean up rootfs")
+		}
+		if err := deleteCgroupPath(state.Cgroup); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to delete cgroup %s", state.Cgroup)
+		}
+	}()
+
+	if state.Status == "stopped" {
+		// The shim saw the init process exit, so we know how it went
+		return shim.StopStatus{
+			Pid:        state.Pid,
+			ExitedAt:   state.ExitedAt,
+			ExitStatus: state.ExitStatus,
+		}, nil
+	}
+
+	exitStatus := process.SignalExitCode(syscall.SIGKILL)
+	if process.IsAlive(state.Pid) {
+		if err := syscall.Kill(state.Pid, syscall.SIGKILL); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to send kill syscall to init process %d", state.Pid)
+		}
+		// The rootfs can't be unmounted while the process still uses it
+		if !process.WaitForExit(state.Pid, stopTimeout) {
+			log.G(ctx).Warnf("init process %d did not exit within %s", state.Pid, stopTimeout)
 		}
+	} else {
+		// The init process exited while nobody was watching
+		exitStatus = unknownExitStatus
 	}
 
 	return shim.StopStatus{
-		Pid:        pid,
+		Pid:        state.Pid,
 		ExitedAt:   time.Now(),
-		ExitStatus: int(exitCodeSignal + syscall.SIGKILL),
+		ExitStatus: exitStatus,
 	}, nil
 }
 
-func readPidFile(id string) (int, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return -1, fmt.Errorf("getting current working directory: %w", err)
-	}
-	path := filepath.Join(filepath.Join(filepath.Dir(cwd), id), initPidFile)
+// How long Stop waits for the killed init process to go away
+const stopTimeout = 5 * time.Second
+
+func readPidFile(bundle string) (int, error) {
+	path := filepath.Join(bundle, initPidFile)
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return -1, err
@@ -197,13 +270,8 @@ func readPidFile(id string) (int, error) {
 // If containerd needs to resort to calling the shim's "stop" command to
 // clean things up, having the process' pid readable from a file is the
 // only way for it to know what init process is associated with the task.
-func writePidFile(id string, pid int) error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("getting current working directory: %w", err)
-	}
-
-	path := filepath.Join(filepath.Join(filepath.Dir(cwd), id), initPidFile)
+func writePidFile(bundle string, pid int) error {
+	path := filepath.Join(bundle, initPidFile)
 	if err := shim.WritePidFile(path, pid); err != nil {
 		return fmt.Errorf("writing pid file of init process: %w", err)
 	}
@@ -213,22 +281,27 @@ func writePidFile(id string, pid int) error {
 	if err := os.Chmod(path, 0644); err != nil {
 		return fmt.Errorf("changing pid file permissions: %w", err)
 	}
-	if err := os.Chown(path, 0, 0); err != nil {
-
-		return fmt.Errorf("changing pid file ownership: %w", err)
-	}
 
 	return nil
 }
 
 func (m bfManager) Info(ctx context.Context, optionsR io.Reader) (*apitypes.RuntimeInfo, error) {
 	log.G(ctx).Debug("Info (manager)")
+	version, revision := buildVersion()
 	info := &apitypes.RuntimeInfo{
 		Name: m.name,
 		Version: &apitypes.RuntimeVersion{
-			Version: "v1.2.0",
+			Version:  version,
+			Revision: revision,
 		},
 	}
+
+	features, err := typeurl.MarshalAnyToProto(Features())
+	if err != nil {
+		return nil, fmt.Errorf("marshalling features: %w", err)
+	}
+	info.Features = features
+
 	return info, nil
 }
 
@@ -237,7 +310,20 @@ var (
 )
 
 type proc struct {
-	pid int
+	pid        int
+	execID     string
+	bundle     string
+	entrypoint string
+	started    bool
+	// Set while Start runs the startContainer hooks without holding the lock
+	starting bool
+	// Path of the rootfs mounted by the shim, if any
+	rootfs string
+	// Cgroup of the process, if the spec asked for one
+	cgroup *cgroup
+	// Lifecycle hooks and annotations from the spec
+	hooks       *specs.Hooks
+	annotations map[string]string
 
 	done       context.Context
 	exitTime   time.Time
@@ -245,6 +331,25 @@ type proc struct {
 
 	stdout string
 	stdin  string
+	stderr string
+
+	// Write end of the stdin pipe of the process. Closed by CloseIO.
+	stdinPipe io.WriteCloser
+	// Fifo from which stdin is copied, closed when the task is deleted
+	stdinFifo io.Closer
+	// Master end of the pty of the process, if it was created with a terminal
+	console console.Console
+}
+
+func (p *proc) status() tasktypes.Status {
+	switch {
+	case p.done.Err() != nil:
+		return tasktypes.Status_STOPPED
+	case p.started:
+		return tasktypes.Status_RUNNING
+	default:
+		return tasktypes.Status_CREATED
+	}
 }
 
 func (pid *proc) String() string {
@@ -256,132 +361,154 @@ func (pid *proc) String() string {
 }
 
 type bfTaskService struct {
-	mu       sync.RWMutex
-	procs    map[string]*proc
+	mu    sync.RWMutex
+	procs map[string]*proc
+	// IDs of the tasks being created. Create does not hold mu while it sets up
+	// the task and runs its hooks.
+	creating map[string]struct{}
+	// State directory of the shim, which holds the task index. Empty when the
+	// manager didn't give one.
+	stateDir string
 	shutdown shutdown.Service
 }
 
 func newTaskService(ctx context.Context, sd shutdown.Service) (taskAPI.TaskService, error) {
-	return &bfTaskService{
+	s := &bfTaskService{
 		procs:    make(map[string]*proc, 1),
+		creating: make(map[string]struct{}),
 		shutdown: sd,
-	}, nil
-}
-
-// RegisterTTRPC allows TTRPC services to be registered with the underlying server
-func (s *bfTaskService) RegisterTTRPC(server *ttrpc.Server) error {
-	taskAPI.RegisterTaskService(server, s)
-	return nil
-}
-
-var (
-	_ = shim.TTRPCService(&bfTaskService{})
-)
-
-func (s *bfTaskService) grab_context(id string) (context.Context, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	proc, ok := s.procs[id]
-	if !ok {
-		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
 	}
-	return proc.done, nil
-}
-
-const configFilename = "config.json"
-
-type root struct {
-	// Path is the path to the rootfs
-	Path string `json:"path"`
-}
 
-type process struct {
-	// Args is the command to run
-	Args []string `json:"args"`
-	// Env is the environment variables to set
-	Env []string `json:"env"`
-	// Cwd is the working directory
-	// Cwd string `json:"cwd"`
-}
-
-type config struct {
-	// RootPath is the path to the rootfs
-	Root    root    `json:"root"`
-	Process process `json:"process"`
-}
+	// The shim runs in the bundle directory of the task which started it. If
+	// there is a state file there, or a task index in the state directory, a
+	// previous incarnation of the shim died and we pick up where it left off.
+	s.stateDir = os.Getenv(stateDirEnv)
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to get working directory, not recovering tasks")
+		return s, nil
+	}
+	bundles := []string{cwd}
+	if s.stateDir != "" {
+		indexed, err := readTaskIndex(s.stateDir)
+		if err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Warn("failed to read task index")
+		}
+		for _, bundle := range indexed {
+			if bundle != cwd {
+				bundles = append(bundles, bundle)
+			}
+		}
+	}
+	for _, bundle := range bundles {
+		s.recover(ctx, bundle)
+	}
 
-type Config struct {
-	Root       string
-	Entrypoint string
-	Path       []string
+	return s, nil
 }
 
-// /var/run/desktop-containerd/daemon/io.containerd.runtime.v2.task/moby/
-
-// ReadOptions reads the option information from the path.
-// When the file does not exist, ReadOptions returns nil without an error.
-func ReadConfig(path string) (*Config, error) {
-	filePath := filepath.Join(path, configFilename)
-	if _, err := os.Stat(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("config file %s not found", configFilename)
+// Write the bundles of the tasks to the task index. Must be called with the
+// lock held.
+func (s *bfTaskService) persistIndex(ctx context.Context) {
+	if s.stateDir == "" {
+		return
+	}
+	if len(s.procs) == 0 {
+		// The shim is about to go away with its last task
+		if err := os.RemoveAll(s.stateDir); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to remove state directory")
 		}
-		return nil, err
+		return
+	}
+	bundles := make([]string, 0, len(s.procs))
+	for _, p := range s.procs {
+		bundles = append(bundles, p.bundle)
 	}
+	slices.Sort(bundles)
+	if err := writeTaskIndex(s.stateDir, bundles); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to write task index")
+	}
+}
 
-	data, err := os.ReadFile(filePath)
+// Rebuild the state of a task from its state file
+func (s *bfTaskService) recover(ctx context.Context, bundle string) {
+	state, err := readTaskState(bundle)
 	if err != nil {
-		return nil, err
-	}
-	var config config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+		if !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Warn("failed to read task state")
+		}
+		return
 	}
+	log.G(ctx).Debugf("recovering task %s (pid:%d, status:%s)", state.ID, state.Pid, state.Status)
 
-	if config.Root.Path == "" {
-		return nil, fmt.Errorf("root path not found in config file %s", configFilename)
+	cg, err := loadCgroup(state.Cgroup)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to load cgroup %s", state.Cgroup)
 	}
 
-	if len(config.Process.Args) != 1 {
-		return nil, fmt.Errorf("incorrect number of args in the CMD. Expected 1, got %d", len(config.Process.Args))
+	done, mark_done := context.WithCancel(context.Background())
+	p := &proc{
+		pid:         state.Pid,
+		bundle:      bundle,
+		entrypoint:  state.Entrypoint,
+		rootfs:      state.Rootfs,
+		cgroup:      cg,
+		hooks:       state.Hooks,
+		annotations: state.Annotations,
+		started:     state.Status != "created",
+		done:        done,
+		stdout:      state.Stdout,
+		stdin:       state.Stdin,
+		stderr:      state.Stderr,
 	}
 
-	arg0 := config.Process.Args[0]
+	s.mu.Lock()
+	s.procs[state.ID] = p
+	s.mu.Unlock()
 
-	// check if the extension is .bf
-	if !(filepath.Ext(arg0) == ".bf" || filepath.Ext(arg0) == ".brainfuck") {
-		return nil, fmt.Errorf("entry point (%s) is not a .bf file", arg0)
+	if state.Status == "stopped" {
+		p.exitStatus = state.ExitStatus
+		p.exitTime = state.ExitedAt
+		mark_done()
+		return
 	}
 
-	// check if the script exists
-	script := filepath.Join(config.Root.Path, arg0)
-	if _, err := os.Stat(script); err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("script %s does not exist: %w", arg0, err)
+	// The init process is not our child anymore, so we can't wait for it. Poll
+	// until it goes away instead.
+	go func() {
+		for process.IsAlive(p.pid) {
+			time.Sleep(recoveredPollInterval)
 		}
-		return nil, fmt.Errorf("checking script %s: %w", arg0, err)
-	}
+		log.G(ctx).Debugf("recovered init process %d exited", p.pid)
+		s.markExited(ctx, state.ID, unknownExitStatus, mark_done)
+	}()
+}
 
-	// Get the PATH environment variable
-	split_path := []string{}
-	for _, env := range config.Process.Env {
-		if env[0:5] == "PATH=" {
-			// Split the PATH variable into a slice
-			path := env[5:]
-			split_path = strings.Split(path, ":")
-			break
-		}
+// Write the state of the task to its bundle
+func (s *bfTaskService) persist(ctx context.Context, id string, p *proc) {
+	if err := writeTaskState(p.bundle, p.state(id)); err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to write state of task %s", id)
 	}
+}
 
-	return &Config{
-		Root:       config.Root.Path,
-		Entrypoint: arg0,
-		Path:       split_path,
-	}, nil
+// RegisterTTRPC allows TTRPC services to be registered with the underlying server
+func (s *bfTaskService) RegisterTTRPC(server *ttrpc.Server) error {
+	taskAPI.RegisterTaskService(server, s)
+	return nil
 }
 
-func (c *Config) FullPath() string {
-	return filepath.Join(c.Root, c.Entrypoint)
+var (
+	_ = shim.TTRPCService(&bfTaskService{})
+)
+
+func (s *bfTaskService) grab_context(id string) (context.Context, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proc, ok := s.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	return proc.done, nil
 }
 
 type finalizer struct {
@@ -417,80 +544,182 @@ func finalize(
 	}
 	log.G(ctx).Debugf("init process %d exited", pid)
 
-	exitStatus := 255
+	exitStatus := unknownExitStatus
 
 	if cmd.ProcessState != nil {
 		switch unixWaitStatus := cmd.ProcessState.Sys().(syscall.WaitStatus); {
 		case cmd.ProcessState.Exited():
 			exitStatus = cmd.ProcessState.ExitCode()
 		case unixWaitStatus.Signaled():
-			exitStatus = exitCodeSignal + int(unixWaitStatus.Signal())
+			exitStatus = process.SignalExitCode(unixWaitStatus.Signal())
 		}
 	} else {
 		log.G(ctx).Warn("init process wait returned without setting process state")
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.markExited(ctx, id, exitStatus, done)
+}
 
+// Record the exit of the init process of a task. The shim stays up until the
+// task is deleted, since other tasks of its group might still use it.
+func (s *bfTaskService) markExited(ctx context.Context, id string, exitStatus int, done func()) {
+	s.mu.Lock()
 	proc, ok := s.procs[id]
 	if !ok {
+		s.mu.Unlock()
 		log.G(ctx).Errorf("failed to write final status of done init process: task was removed")
+		done()
+		return
 	}
 
 	proc.exitStatus = exitStatus
 	proc.exitTime = time.Now()
 	done()
+	s.persist(ctx, id, proc)
 
-	// Check if all the procs have exited
-	all_exited := func() bool {
-		for _, proc := range s.procs {
-			if !(proc.done.Err() != nil) {
-				return false
-			}
-		}
-		return true
-	}()
+	var poststop []specs.Hook
+	if proc.hooks != nil {
+		poststop = proc.hooks.Poststop
+	}
+	state := proc.ociState(id, specs.StateStopped)
+	s.mu.Unlock()
 
-	if all_exited {
-		log.G(ctx).Debug("all procs exited. shutting down the shim")
-		s.shutdown.Shutdown()
+	// Hooks may take long, so they run without the lock held. The context of the
+	// request which created the task is long gone.
+	if err := runHooks(context.WithoutCancel(ctx), poststop, state); err != nil {
+		log.G(ctx).WithError(err).Warn("poststop hook failed")
 	}
 }
 
-const start_stopped_script = `
-#!/bin/sh
-kill -STOP $$
-exec $@
-`
+// Directory in the bundle for the files shared by the shim and the init
+// process. It belongs to the user the init process runs as, so that the process
+// can still write its metrics after dropping root.
+const interpreterDirname = "bf"
+
+// The init process waits on this fifo until the task is started
+const startFifoFilename = "bf.start"
+
+func startFifoPath(bundle string) string {
+	return filepath.Join(bundle, interpreterDirname, startFifoFilename)
+}
+
+// Create the directory for the files shared with the init process, owned by
+// the user it runs as
+func makeInterpreterDir(bundle string, cred *syscall.Credential) error {
+	dir := filepath.Join(bundle, interpreterDirname)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	if cred != nil {
+		if err := os.Chown(dir, int(cred.Uid), int(cred.Gid)); err != nil {
+			return fmt.Errorf("changing ownership of %s: %w", dir, err)
+		}
+	}
+	return nil
+}
 
 const command_wait_delay = 100 * time.Millisecond
 
+// The interpreter is the shim binary itself, run with the brainfuck argument.
+// Tests run a build of the shim binary instead of the test binary.
+var interpreterExecutable = os.Executable
+
 // Create a new container
 func (s *bfTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *taskAPI.CreateTaskResponse, retErr error) {
+	// Reserve the ID, then set up the task without holding the lock, since its
+	// hooks may take long
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if _, ok := s.procs[r.ID]; ok {
+		s.mu.Unlock()
 		return nil, errdefs.ErrAlreadyExists
 	}
+	if _, ok := s.creating[r.ID]; ok {
+		s.mu.Unlock()
+		return nil, errdefs.ErrAlreadyExists
+	}
+	s.creating[r.ID] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.creating, r.ID)
+		s.mu.Unlock()
+	}()
+
+	// The rootfs goes where the config says, so read that before mounting it
+	root, err := rootPath(r.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	rootfs, err := mountRootfs(root, r.Rootfs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			if err := unmountRootfs(rootfs); err != nil {
+				log.G(ctx).WithError(err).Warn("failed to clean up rootfs")
+			}
+		}
+	}()
 
 	config, err := ReadConfig(r.Bundle)
 	if err != nil {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
+	if len(config.Args) > 0 {
+		log.G(ctx).Warnf("ignoring arguments %v to %s: brainfuck programs take no arguments", config.Args, config.Entrypoint)
+	}
+	if config.PodSandbox {
+		log.G(ctx).Debugf("task %s is a pod sandbox, holding it open without a program", r.ID)
+	}
 
-	start_stopped_script_path := filepath.Join(r.Bundle, "start-stopped.sh")
-	if err := os.WriteFile(start_stopped_script_path, []byte(start_stopped_script), 0755); err != nil {
-		return nil, fmt.Errorf("writing start-stopped.sh: %w", err)
+	options, err := interpreterOptions(r.Options, config.Env, config.Annotations)
+	if err != nil {
+		return nil, errdefs.ErrInvalidArgument.WithMessage(fmt.Sprintf("interpreter options: %v", err))
+	}
+	profile, err := profileEnabled(config.Annotations)
+	if err != nil {
+		return nil, errdefs.ErrInvalidArgument.WithMessage(err.Error())
 	}
 
-	self, err := os.Executable()
+	cred := credential(config.User)
+	if err := makeInterpreterDir(r.Bundle, cred); err != nil {
+		return nil, err
+	}
+
+	start_fifo := startFifoPath(r.Bundle)
+	os.Remove(start_fifo)
+	if err := syscall.Mkfifo(start_fifo, 0600); err != nil {
+		return nil, fmt.Errorf("creating start fifo: %w", err)
+	}
+	if cred != nil {
+		if err := os.Chown(start_fifo, int(cred.Uid), int(cred.Gid)); err != nil {
+			return nil, fmt.Errorf("changing ownership of start fifo: %w", err)
+		}
+	}
+
+	self, err := interpreterExecutable()
 	if err != nil {
 		return nil, fmt.Errorf("getting executable of current process: %w", err)
 	}
 
-	cmd := exec.CommandContext(ctx, "/bin/sh", start_stopped_script_path, self, "brainfuck", "-file", config.FullPath())
+	args := []string{"brainfuck",
+		"-metrics", MetricsPath(r.Bundle),
+		"-start-fifo", start_fifo,
+		"-sandbox",
+		"-log-fd", strconv.Itoa(interpreterLogFd),
+		"-log-level", interpreterLogLevel().String(),
+	}
+	if config.PodSandbox {
+		args = append(args, "-hold")
+	} else {
+		args = append(args, "-file", config.FullPath())
+		args = append(args, options.Flags()...)
+		if profile {
+			args = append(args, "-profile", ProfilePath(r.Bundle))
+		}
+	}
+	cmd := exec.CommandContext(ctx, self, args...)
 
 	// DEBUG script to run a long running process
 	// cmd := exec.CommandContext(ctx, "sh", "-c",
@@ -499,97 +728,112 @@ func (s *bfTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest
 	// 		"done",
 	// )
 
-	// STDOUT
-	ok, err := fifo.IsFifo(r.Stdout)
-	if err != nil {
-		return nil, fmt.Errorf("checking whether file %s is a fifo: %w", r.Stdout, err)
-	}
-	if !ok {
-		return nil, fmt.Errorf("file %s is not a fifo", r.Stdout)
-	}
+	var stdin_pipe io.WriteCloser
+	var stdin_fifo io.Closer
+	var pty console.Console
+	if r.Terminal {
+		var slave *os.File
+		if pty, slave, stdin_fifo, err = setupTerminal(ctx, cmd, r.ID, r.Stdin, r.Stdout); err != nil {
+			return nil, fmt.Errorf("setting up terminal: %w", err)
+		}
+		// The slave end belongs to the init process once it's started
+		defer slave.Close()
 
-	var fw io.WriteCloser
-	if fw, err = fifo.OpenFifo(ctx, r.Stdout, syscall.O_WRONLY, 0); err != nil {
-		return nil, fmt.Errorf("opening write only fifo %s: %w", r.Stdout, err)
-	}
+		// Make the pty the controlling terminal of the init process. This also
+		// puts it in its own process group, so that Kill can signal all of its
+		// descendants at once.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	} else {
+		if stdin_pipe, stdin_fifo, err = setupPipes(ctx, cmd, r.ID, r.Stdin, r.Stdout, r.Stderr); err != nil {
+			return nil, fmt.Errorf("setting up stdio: %w", err)
+		}
 
-	stdout_pipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("getting stdout pipe: %w", err)
+		// Put the init process in its own process group so that Kill can signal
+		// all of its descendants at once
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	}
 
-	// Connect the stdout pipe to the fifo
-	go func() {
-		if _, err := io.Copy(fw, stdout_pipe); err != nil {
-			log.G(ctx).WithError(err).Errorf("failed to copy stdout pipe to fifo %s", r.Stdout)
-		}
-	}()
+	// Run as the user from the spec. Mind that this has to be set before the
+	// sandbox maps the user into its user namespace.
+	cmd.SysProcAttr.Credential = cred
 
-	// STDIN
+	// Give the interpreter its own namespaces (where available)
+	sandbox.Configure(cmd.SysProcAttr)
 
-	ok, err = fifo.IsFifo(r.Stdin)
+	cmd.WaitDelay = command_wait_delay
+
+	log_r, log_w, err := os.Pipe()
 	if err != nil {
-		return nil, fmt.Errorf("checking whether file %s is a fifo: %w", r.Stdin, err)
-	}
-	if !ok {
-		return nil, fmt.Errorf("file %s is not a fifo", r.Stdin)
-	}
-	var fr io.ReadCloser
-	if fr, err = fifo.OpenFifo(ctx, r.Stdin, syscall.O_RDONLY, 0); err != nil {
-		return nil, fmt.Errorf("opening read only fifo %s: %w", r.Stdin, err)
+		return nil, fmt.Errorf("creating log pipe: %w", err)
 	}
+	cmd.ExtraFiles = []*os.File{log_w}
 
-	stdin_pipe, err := cmd.StdinPipe()
+	// Start the process (it waits on the start fifo)
+	err = cmd.Start()
+	log_w.Close()
 	if err != nil {
-		return nil, fmt.Errorf("getting stdin pipe: %w", err)
+		log_r.Close()
+		return nil, fmt.Errorf("running init command: %w", err)
 	}
-
-	// Connect the stdin pipe to the fifo
-	go func() {
-		if _, err := io.Copy(stdin_pipe, fr); err != nil {
-			log.G(ctx).WithError(err).Errorf("failed to copy fifo %s to stdin pipe", r.Stdin)
+	go forwardLogs(ctx, r.ID, cmd.Process.Pid, log_r)
+	defer func() {
+		if retErr != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
 		}
 	}()
 
-	// STDERR
-	stderr := r.Stderr
-	if stderr == "" {
-		stderr = r.Stdout
-	}
-
-	ok, err = fifo.IsFifo(stderr)
-	if err != nil {
-		return nil, fmt.Errorf("checking whether file %s is a fifo: %w", stderr, err)
-	}
-	if !ok {
-		return nil, fmt.Errorf("file %s is not a fifo", stderr)
-	}
+	pid := cmd.Process.Pid
 
-	stderr_pipe, err := cmd.StderrPipe()
+	// The process has not started the program yet, so it can't escape its limits
+	cg, err := newCgroup(config.Spec.Linux, pid)
 	if err != nil {
-		return nil, fmt.Errorf("getting stderr pipe: %w", err)
-	}
-
-	var fe io.WriteCloser
-	if fe, err = fifo.OpenFifo(ctx, stderr, syscall.O_WRONLY, 0); err != nil {
-		return nil, fmt.Errorf("opening read only fifo %s: %w", stderr, err)
+		if !errors.Is(err, errCgroupsUnsupported) {
+			return nil, fmt.Errorf("setting up cgroup: %w", err)
+		}
+		log.G(ctx).WithError(err).Warn("running without a cgroup")
 	}
-
-	// Connect the stderr pipe to the fifo
-	go func() {
-		if _, err := io.Copy(fe, stderr_pipe); err != nil {
-			log.G(ctx).WithError(err).Errorf("failed to copy stderr pipe to fifo %s", stderr)
+	defer func() {
+		if retErr != nil && cg != nil {
+			if err := cg.delete(); err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to clean up cgroup %s", cg.path)
+			}
 		}
 	}()
 
-	cmd.WaitDelay = command_wait_delay
+	if err := setRlimits(pid, config.Rlimits); err != nil {
+		return nil, err
+	}
 
-	// Start the process (in a suspended state)
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("running init command: %w", err)
+	p := &proc{
+		pid:         pid,
+		bundle:      r.Bundle,
+		entrypoint:  config.FullPath(),
+		rootfs:      rootfs,
+		cgroup:      cg,
+		hooks:       config.Hooks,
+		annotations: config.Annotations,
+		stdout:      r.Stdout,
+		stdin:       r.Stdin,
+		stderr:      r.Stderr,
+
+		stdinPipe: stdin_pipe,
+		stdinFifo: stdin_fifo,
+		console:   pty,
+	}
+
+	if hooks := config.Hooks; hooks != nil {
+		state := p.ociState(r.ID, specs.StateCreating)
+		// prestart is deprecated in favour of createRuntime, but runs at the same point
+		for _, stage := range [][]specs.Hook{hooks.Prestart, hooks.CreateRuntime, hooks.CreateContainer} {
+			if err := runHooks(ctx, stage, state); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	pid := cmd.Process.Pid
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	doneCtx, mark_done := context.WithCancel(context.Background())
 
@@ -603,15 +847,15 @@ func (s *bfTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest
 
 	finalizer.schedule(ctx)
 
-	writePidFile(r.ID, pid)
-
-	s.procs[r.ID] = &proc{
-		pid:    pid,
-		done:   doneCtx,
-		stdout: r.Stdout,
-		stdin:  r.Stdin,
+	if err := writePidFile(r.Bundle, pid); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to write pid file")
 	}
 
+	p.done = doneCtx
+	s.procs[r.ID] = p
+	s.persist(ctx, r.ID, p)
+	s.persistIndex(ctx)
+
 	return &taskAPI.CreateTaskResponse{
 		Pid: uint32(pid),
 	}, nil
@@ -621,20 +865,67 @@ func (s *bfTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest
 func (s *bfTaskService) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.StartResponse, error) {
 	log.G(ctx).Debug("start (service)")
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
 	proc, ok := s.procs[r.ID]
 	if !ok {
+		s.mu.Unlock()
 		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
 	}
 
-	cmd := exec.CommandContext(ctx, "kill", "-CONT", strconv.Itoa(proc.pid))
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("starting init command: %w", err)
+	if proc.started || proc.starting {
+		s.mu.Unlock()
+		return nil, errdefs.ErrFailedPrecondition.WithMessage(fmt.Sprintf("init process %d already started", proc.pid))
+	}
+
+	// Copy what the hooks need, and run them without holding the lock
+	proc.starting = true
+	pid, bundle := proc.pid, proc.bundle
+	hooks := proc.hooks
+	if hooks == nil {
+		hooks = &specs.Hooks{}
+	}
+	created_state := proc.ociState(r.ID, specs.StateCreated)
+	running_state := proc.ociState(r.ID, specs.StateRunning)
+	s.mu.Unlock()
+
+	// Take the lock again to record the outcome
+	finish := func(started bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		proc.starting = false
+		if started {
+			proc.started = true
+		}
+		if s.procs[r.ID] == proc {
+			s.persist(ctx, r.ID, proc)
+		}
+	}
+
+	if err := runHooks(ctx, hooks.StartContainer, created_state); err != nil {
+		// The container must not run when a startContainer hook fails
+		syscall.Kill(pid, syscall.SIGKILL)
+		finish(false)
+		return nil, err
+	}
+
+	// Opening (and closing) the write end of the start fifo releases the init
+	// process
+	start_fifo := startFifoPath(bundle)
+	f, err := fifo.OpenFifo(ctx, start_fifo, syscall.O_WRONLY, 0)
+	if err != nil {
+		finish(false)
+		return nil, fmt.Errorf("opening start fifo: %w", err)
+	}
+	f.Close()
+	os.Remove(start_fifo)
+	finish(true)
+
+	if err := runHooks(ctx, hooks.Poststart, running_state); err != nil {
+		log.G(ctx).WithError(err).Warn("poststart hook failed")
 	}
 
 	return &taskAPI.StartResponse{
-		Pid: uint32(proc.pid),
+		Pid: uint32(pid),
 	}, nil
 }
 
@@ -651,7 +942,22 @@ func (s *bfTaskService) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*
 	}
 
 	if proc.done.Err() != nil {
+		if proc.console != nil {
+			proc.console.Close()
+		}
+		if proc.stdinFifo != nil {
+			proc.stdinFifo.Close()
+		}
+		if err := unmountRootfs(proc.rootfs); err != nil {
+			return nil, err
+		}
+		if proc.cgroup != nil {
+			if err := proc.cgroup.delete(); err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to delete cgroup %s", proc.cgroup.path)
+			}
+		}
 		delete(s.procs, r.ID)
+		s.persistIndex(ctx)
 	} else {
 		return nil, errdefs.ErrFailedPrecondition.WithMessage(fmt.Sprintf("init process %d is not done yet", proc.pid))
 	}
@@ -672,6 +978,26 @@ func (s *bfTaskService) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest)
 // ResizePty of a process
 func (s *bfTaskService) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (*ptypes.Empty, error) {
 	log.G(ctx).Debug("resizepty (service)")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	if proc.console == nil {
+		return nil, errdefs.ErrFailedPrecondition.WithMessage(fmt.Sprintf("init process %d does not have a terminal", proc.pid))
+	}
+
+	ws := console.WinSize{
+		Width:  uint16(r.Width),
+		Height: uint16(r.Height),
+	}
+	if err := proc.console.Resize(ws); err != nil {
+		return nil, fmt.Errorf("resizing terminal of init process %d: %w", proc.pid, err)
+	}
+
 	return &ptypes.Empty{}, nil
 }
 
@@ -686,17 +1012,14 @@ func (s *bfTaskService) State(ctx context.Context, r *taskAPI.StateRequest) (*ta
 		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
 	}
 
-	status := tasktypes.Status_RUNNING
-	if proc.done.Err() != nil {
-		status = tasktypes.Status_STOPPED
-	}
-
 	return &taskAPI.StateResponse{
 		ID:         r.ID,
+		ExecID:     proc.execID,
 		Pid:        uint32(proc.pid),
-		Status:     status,
+		Status:     proc.status(),
 		Stdout:     proc.stdout,
 		Stdin:      proc.stdin,
+		Stderr:     proc.stderr,
 		ExitStatus: uint32(proc.exitStatus),
 		ExitedAt:   protobuf.ToTimestamp(proc.exitTime),
 	}, nil
@@ -718,6 +1041,10 @@ func (s *bfTaskService) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*
 func (s *bfTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptypes.Empty, error) {
 	log.G(ctx).Debug("kill (service)")
 
+	// Only SIGKILL is guaranteed to terminate the process. Any other signal
+	// might be handled (or ignored), so we don't wait for the process to exit.
+	wait := false
+
 	already_exited, err := func() (bool, error) {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
@@ -734,17 +1061,22 @@ func (s *bfTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptyp
 
 		if proc.pid > 0 {
 			p, err := os.FindProcess(proc.pid)
-			log.G(ctx).Debugf("kill id:%s execid:%s pid:%d sig:%d err:%v", r.ID, r.ExecID, proc.pid, r.Signal, err)
+			log.G(ctx).Debugf("kill id:%s execid:%s pid:%d sig:%d all:%t err:%v", r.ID, r.ExecID, proc.pid, r.Signal, r.All, err)
 			// The POSIX standard specifies that a null-signal can be sent to check
 			// whether a PID is valid.
 			if err := p.Signal(syscall.Signal(0)); err == nil {
-				// log.G(ctx).Debugf("kill id:%s execid:%s pid:%d sig:%d", r.ID, r.ExecID, proc.pid, r.Signal)
-				// TODO: use the signal from the request
-				// sig := syscall.Signal(r.Signal)
-				sig := syscall.Signal(9)
-				if err := p.Signal(sig); err != nil {
-					return false, fmt.Errorf("sending %s to init process: %w", sig, err)
+				sig := syscall.Signal(r.Signal)
+				if r.All {
+					// The init process is the leader of its own process group
+					if err := syscall.Kill(-proc.pid, sig); err != nil {
+						return false, fmt.Errorf("sending %s to init process group: %w", sig, err)
+					}
+				} else {
+					if err := p.Signal(sig); err != nil {
+						return false, fmt.Errorf("sending %s to init process: %w", sig, err)
+					}
 				}
+				wait = sig == syscall.SIGKILL
 			}
 		}
 		return false, nil
@@ -757,7 +1089,7 @@ func (s *bfTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptyp
 
 	if already_exited {
 		log.G(ctx).Warnf("task already exited: %s", r.ID)
-	} else {
+	} else if wait {
 		done, err := s.grab_context(r.ID)
 		if err != nil {
 			return nil, err
@@ -776,13 +1108,60 @@ func (s *bfTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptyp
 // Pids returns all pids inside the container
 func (s *bfTaskService) Pids(ctx context.Context, r *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
 	log.G(ctx).Debug("pids (service)")
-	return nil, errdefs.ErrNotImplemented.WithMessage("Pids (task)")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	// NOTE: Exec is not implemented, so the init process is the only process
+	// in the task
+	info, err := typeurl.MarshalAny(&types.ProcessDetails{
+		ExecId:     proc.execID,
+		Entrypoint: proc.entrypoint,
+		Status:     statusString(proc.status()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling process details: %w", err)
+	}
+	procs := []*tasktypes.ProcessInfo{{
+		Pid:  uint32(proc.pid),
+		Info: protobuf.FromAny(info),
+	}}
+
+	return &taskAPI.PidsResponse{
+		Processes: procs,
+	}, nil
 }
 
 // CloseIO of a process
 func (s *bfTaskService) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*ptypes.Empty, error) {
 	log.G(ctx).Debug("closeio (service)")
-	return nil, errdefs.ErrNotImplemented.WithMessage("CloseIO (task)")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	switch {
+	case !r.Stdin:
+	case proc.stdinPipe != nil:
+		// The interpreter sees EOF on its next read from stdin
+		if err := proc.stdinPipe.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return nil, fmt.Errorf("closing stdin of init process %d: %w", proc.pid, err)
+		}
+	case proc.console != nil:
+		// A terminal can't be closed for reading only
+		if err := sendEOF(proc.console); err != nil {
+			return nil, fmt.Errorf("closing stdin of init process %d: %w", proc.pid, err)
+		}
+	}
+
+	return &ptypes.Empty{}, nil
 }
 
 // Checkpoint the container
@@ -812,6 +1191,16 @@ func (s *bfTaskService) Connect(ctx context.Context, r *taskAPI.ConnectRequest)
 func (s *bfTaskService) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (*ptypes.Empty, error) {
 	log.G(ctx).Debug("shutdown (service)")
 
+	s.mu.RLock()
+	tasks := len(s.procs) + len(s.creating)
+	s.mu.RUnlock()
+	if tasks > 0 {
+		// Other containers of the group still use the shim
+		log.G(ctx).Debugf("not shutting down, %d tasks left", tasks)
+		return &ptypes.Empty{}, nil
+	}
+
+	log.G(ctx).Debug("last task deleted. shutting down the shim")
 	s.shutdown.Shutdown()
 	return &ptypes.Empty{}, nil
 }
@@ -819,18 +1208,63 @@ func (s *bfTaskService) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest
 // Stats returns container level system stats for a container and its processes
 func (s *bfTaskService) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
 	log.G(ctx).Debug("stats (service)")
-	// return empty stats
-	stats := &taskAPI.StatsResponse{
-		Stats: &anypb.Any{},
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	metrics, err := ReadMetrics(MetricsPath(proc.bundle))
+	if err != nil {
+		return nil, fmt.Errorf("reading metrics of init process %d: %w", proc.pid, err)
+	}
+	if proc.cgroup != nil {
+		if metrics.Cgroup, err = proc.cgroup.manager.Stat(); err != nil {
+			return nil, fmt.Errorf("reading cgroup metrics of init process %d: %w", proc.pid, err)
+		}
+	}
+
+	stats, err := typeurl.MarshalAny(metrics)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling metrics: %w", err)
 	}
-	return stats, nil
 
+	return &taskAPI.StatsResponse{
+		Stats: protobuf.FromAny(stats),
+	}, nil
 }
 
 // Update the live container
 func (s *bfTaskService) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
 	log.G(ctx).Debug("update (service)")
-	return nil, errdefs.ErrAborted.WithMessage("Update (task)")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	if proc.cgroup == nil {
+		return nil, errdefs.ErrFailedPrecondition.WithMessage(fmt.Sprintf("init process %d is not in a cgroup", proc.pid))
+	}
+
+	v, err := typeurl.UnmarshalAny(r.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling resources: %w", err)
+	}
+	resources, ok := v.(*specs.LinuxResources)
+	if !ok {
+		return nil, errdefs.ErrInvalidArgument.WithMessage(fmt.Sprintf("unexpected resources type %T", v))
+	}
+
+	if err := proc.cgroup.update(resources); err != nil {
+		return nil, fmt.Errorf("updating cgroup %s: %w", proc.cgroup.path, err)
+	}
+
+	return &ptypes.Empty{}, nil
 }
 
 // Wait for a process to exit
@@ -0,0 +1,152 @@
+package shim
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const stateFilename = "bf.state.json"
+
+// Persistent state of a task, kept in its bundle. Lets a restarted shim, or
+// the `delete` command of the shim, find out what happened to the task.
+type taskState struct {
+	ID         string    `json:"id"`
+	Pid        int       `json:"pid"`
+	Status     string    `json:"status"`
+	ExitStatus int       `json:"exit_status"`
+	ExitedAt   time.Time `json:"exited_at,omitempty"`
+	Entrypoint string    `json:"entrypoint"`
+	Rootfs     string    `json:"rootfs,omitempty"`
+	Cgroup     string    `json:"cgroup,omitempty"`
+	Terminal   bool      `json:"terminal"`
+	Stdin      string    `json:"stdin,omitempty"`
+	Stdout     string    `json:"stdout,omitempty"`
+	Stderr     string    `json:"stderr,omitempty"`
+
+	Hooks       *specs.Hooks      `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func statePath(bundle string) string {
+	return filepath.Join(bundle, stateFilename)
+}
+
+// Write the state file of the task. The file is replaced atomically so that a
+// shim dying half way through never leaves a corrupt state behind.
+func writeTaskState(bundle string, state *taskState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := statePath(bundle)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func readTaskState(bundle string) (*taskState, error) {
+	data, err := os.ReadFile(statePath(bundle))
+	if err != nil {
+		return nil, err
+	}
+	var state taskState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing state file: %w", err)
+	}
+	return &state, nil
+}
+
+// The bundles of the tasks a shim serves, kept in the state directory of the
+// shim. A restarted shim recovers all the tasks of its group from it, not only
+// the one it was started for.
+const taskIndexFilename = "bf.tasks.json"
+
+// Environment variable through which the manager tells the shim its state
+// directory
+const stateDirEnv = "BF_SHIM_STATE_DIR"
+
+// State directory of the shim of a group. It sits next to the socket of
+// containerd rather than in a bundle, since containerd deletes the bundle of
+// a task when the task is deleted, while the shim keeps serving the rest of
+// its group.
+func shimStateDir(address string, namespace string, group string) string {
+	root := filepath.Dir(strings.TrimPrefix(address, "unix://"))
+	// The group comes from an annotation, so it is no safe path element
+	return filepath.Join(root, "io.containerd.bf.v1", namespace, fmt.Sprintf("%x", sha256.Sum256([]byte(group))))
+}
+
+func writeTaskIndex(dir string, bundles []string) error {
+	data, err := json.Marshal(bundles)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0711); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+	path := filepath.Join(dir, taskIndexFilename)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing task index: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func readTaskIndex(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, taskIndexFilename))
+	if err != nil {
+		return nil, err
+	}
+	var bundles []string
+	if err := json.Unmarshal(data, &bundles); err != nil {
+		return nil, fmt.Errorf("parsing task index: %w", err)
+	}
+	return bundles, nil
+}
+
+func (p *proc) state(id string) *taskState {
+	state := &taskState{
+		ID:         id,
+		Pid:        p.pid,
+		Status:     statusString(p.status()),
+		Entrypoint: p.entrypoint,
+		Rootfs:     p.rootfs,
+		Terminal:   p.console != nil,
+		Stdin:      p.stdin,
+		Stdout:     p.stdout,
+		Stderr:     p.stderr,
+
+		Hooks:       p.hooks,
+		Annotations: p.annotations,
+	}
+	if p.cgroup != nil {
+		state.Cgroup = p.cgroup.path
+	}
+	if p.done.Err() != nil {
+		state.ExitStatus = p.exitStatus
+		state.ExitedAt = p.exitTime
+	}
+	return state
+}
+
+func statusString(status tasktypes.Status) string {
+	switch status {
+	case tasktypes.Status_CREATED:
+		return "created"
+	case tasktypes.Status_RUNNING:
+		return "running"
+	case tasktypes.Status_STOPPED:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,155 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestTaskState_WriteRead(t *testing.T) {
+	bundle := t.TempDir()
+	state := &taskState{
+		ID:     "task",
+		Pid:    1234,
+		Status: "running",
+		Stdout: "/run/fifo/stdout",
+		Hooks: &specs.Hooks{
+			Poststop: []specs.Hook{{Path: "/bin/true"}},
+		},
+		Annotations: map[string]string{"key": "value"},
+	}
+	utils.AssertNoError(t, writeTaskState(bundle, state))
+
+	read, err := readTaskState(bundle)
+	utils.AssertNoError(t, err)
+	utils.AssertEqualWithComparator(t, *read, *state, func(a, b taskState) bool {
+		return reflect.DeepEqual(a, b)
+	})
+}
+
+func TestStop_ReportsRecordedExit(t *testing.T) {
+	bundle := t.TempDir()
+	exitedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	utils.AssertNoError(t, writeTaskState(bundle, &taskState{
+		ID:         "task",
+		Pid:        1234,
+		Status:     "stopped",
+		ExitStatus: 3,
+		ExitedAt:   exitedAt,
+		Cgroup:     "/test/task",
+	}))
+	cgroup := fakeCgroup(t, "/test/task")
+
+	t.Chdir(bundle)
+	status, err := NewManager("test").Stop(context.Background(), "task")
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, status.Pid, 1234)
+	utils.AssertEqual(t, status.ExitStatus, 3)
+	utils.Assert(t, status.ExitedAt.Equal(exitedAt), "wrong exit time")
+	_, err = os.Stat(cgroup)
+	utils.Assert(t, os.IsNotExist(err), "expected the cgroup to be deleted")
+}
+
+func TestStop_KillsRunning(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	utils.AssertNoError(t, cmd.Start())
+	bundle := t.TempDir()
+	utils.AssertNoError(t, writeTaskState(bundle, &taskState{ID: "task", Pid: cmd.Process.Pid, Status: "running", Cgroup: "/test/task"}))
+	cgroup := fakeCgroup(t, "/test/task")
+
+	// Stop returns once the process is gone, which includes it being a zombie
+	// nobody has reaped yet
+	t.Chdir(bundle)
+	status, err := NewManager("test").Stop(context.Background(), "task")
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, status.ExitStatus, process.SignalExitCode(syscall.SIGKILL))
+	utils.Assert(t, !process.IsAlive(cmd.Process.Pid), "expected the process to be gone")
+	_, err = os.Stat(cgroup)
+	utils.Assert(t, os.IsNotExist(err), "expected the cgroup to be deleted")
+
+	cmd.Wait()
+	utils.AssertEqual(t, cmd.ProcessState.Sys().(syscall.WaitStatus).Signal(), syscall.SIGKILL)
+}
+
+func TestNewTaskService_RecoversIndexedTasks(t *testing.T) {
+	// The shim was started for a, and then served b too
+	a, b := t.TempDir(), t.TempDir()
+	utils.AssertNoError(t, writeTaskState(a, &taskState{ID: "a", Pid: 1234, Status: "stopped", ExitStatus: 1}))
+	utils.AssertNoError(t, writeTaskState(b, &taskState{ID: "b", Pid: 1235, Status: "stopped", ExitStatus: 2}))
+	stateDir := filepath.Join(t.TempDir(), "state")
+	utils.AssertNoError(t, writeTaskIndex(stateDir, []string{a, b}))
+	t.Setenv(stateDirEnv, stateDir)
+
+	t.Chdir(a)
+	ctx, sd := shutdown.WithShutdown(context.Background())
+	defer sd.Shutdown()
+	service, err := newTaskService(ctx, sd)
+	utils.AssertNoError(t, err)
+	s := service.(*bfTaskService)
+	utils.AssertEqual(t, len(s.procs), 2)
+	utils.AssertEqual(t, s.procs["a"].exitStatus, 1)
+	utils.AssertEqual(t, s.procs["b"].bundle, b)
+
+	_, err = s.Delete(ctx, &taskAPI.DeleteRequest{ID: "b"})
+	utils.AssertNoError(t, err)
+	bundles, err := readTaskIndex(stateDir)
+	utils.AssertNoError(t, err)
+	utils.AssertEqualArrays(t, bundles, []string{a})
+
+	// The state directory goes away with the last task
+	_, err = s.Delete(ctx, &taskAPI.DeleteRequest{ID: "a"})
+	utils.AssertNoError(t, err)
+	_, err = os.Stat(stateDir)
+	utils.Assert(t, os.IsNotExist(err), "expected the state directory to be removed")
+}
+
+func TestNewTaskService_RecoversAfterFirstTaskDeleted(t *testing.T) {
+	// The shim was started for a, and then served b and c too
+	a, b, c := t.TempDir(), t.TempDir(), t.TempDir()
+	utils.AssertNoError(t, writeTaskState(a, &taskState{ID: "a", Pid: 1234, Status: "stopped"}))
+	utils.AssertNoError(t, writeTaskState(b, &taskState{ID: "b", Pid: 1235, Status: "stopped", ExitStatus: 2}))
+	utils.AssertNoError(t, writeTaskState(c, &taskState{ID: "c", Pid: 1236, Status: "stopped", ExitStatus: 3}))
+	stateDir := filepath.Join(t.TempDir(), "state")
+	utils.AssertNoError(t, writeTaskIndex(stateDir, []string{a, b, c}))
+	t.Setenv(stateDirEnv, stateDir)
+
+	t.Chdir(a)
+	ctx, sd := shutdown.WithShutdown(context.Background())
+	service, err := newTaskService(ctx, sd)
+	utils.AssertNoError(t, err)
+	_, err = service.Delete(ctx, &taskAPI.DeleteRequest{ID: "a"})
+	utils.AssertNoError(t, err)
+	sd.Shutdown()
+	// containerd removes the bundle of a deleted task
+	utils.AssertNoError(t, os.RemoveAll(a))
+
+	// The shim dies, and gets started again for a new task of the group
+	t.Chdir(t.TempDir())
+	ctx, sd = shutdown.WithShutdown(context.Background())
+	defer sd.Shutdown()
+	service, err = newTaskService(ctx, sd)
+	utils.AssertNoError(t, err)
+	s := service.(*bfTaskService)
+	utils.AssertEqual(t, len(s.procs), 2)
+	utils.AssertEqual(t, s.procs["b"].exitStatus, 2)
+	utils.AssertEqual(t, s.procs["c"].exitStatus, 3)
+}
+
+func TestShimStateDir(t *testing.T) {
+	dir := shimStateDir("/run/containerd/containerd.sock", "default", "../../etc")
+	utils.Assert(t, strings.HasPrefix(dir, "/run/containerd/io.containerd.bf.v1/default/"), "unexpected state directory "+dir)
+	utils.AssertEqual(t, filepath.Dir(dir), "/run/containerd/io.containerd.bf.v1/default")
+	utils.AssertEqual(t, shimStateDir("unix:///run/containerd/containerd.sock", "default", "../../etc"), dir)
+}
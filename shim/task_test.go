@@ -0,0 +1,259 @@
+package shim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MarcinKonowalczyk/runbf/internal/process"
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/fifo"
+)
+
+// Build of the shim binary, which the tasks of the tests run as interpreter
+var shimBinary struct {
+	once sync.Once
+	dir  string
+	path string
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if shimBinary.dir != "" {
+		os.RemoveAll(shimBinary.dir)
+	}
+	os.Exit(code)
+}
+
+func useShimBinary(t *testing.T) {
+	t.Helper()
+	shimBinary.once.Do(func() {
+		if shimBinary.dir, shimBinary.err = os.MkdirTemp("", "bf-shim-test"); shimBinary.err != nil {
+			return
+		}
+		shimBinary.path = filepath.Join(shimBinary.dir, "containerd-shim-brainfuck-v1")
+		cmd := exec.Command("go", "build", "-o", shimBinary.path, "../cmd/containerd-shim-brainfuck-v1.go")
+		cmd.Stderr = os.Stderr
+		shimBinary.err = cmd.Run()
+	})
+	if shimBinary.err != nil {
+		t.Fatalf("building shim binary: %v", shimBinary.err)
+	}
+	old := interpreterExecutable
+	interpreterExecutable = func() (string, error) { return shimBinary.path, nil }
+	t.Cleanup(func() { interpreterExecutable = old })
+}
+
+// A task run by a test, with a fifo as its stdin and a log file as its output
+type testTask struct {
+	id      string
+	ctx     context.Context
+	service *bfTaskService
+	stdin   io.WriteCloser
+	stdout  string
+}
+
+// Create a task which runs the program. It gets killed and deleted at the end
+// of the test.
+func createTask(t *testing.T, program string, terminal bool) *testTask {
+	t.Helper()
+	useShimBinary(t)
+
+	bundle := makeBundle(t, []string{"main.bf"}, map[string]any{
+		"args":     []string{"/main.bf"},
+		"cwd":      "/",
+		"terminal": terminal,
+	})
+	utils.AssertNoError(t, os.WriteFile(filepath.Join(bundle, "rootfs", "main.bf"), []byte(program), 0644))
+
+	dir := t.TempDir()
+	stdin := filepath.Join(dir, "stdin")
+	utils.AssertNoError(t, syscall.Mkfifo(stdin, 0600))
+
+	ctx := namespaces.WithNamespace(context.Background(), "test")
+	ctx, sd := shutdown.WithShutdown(ctx)
+	t.Cleanup(sd.Shutdown)
+	t.Chdir(bundle)
+	service, err := newTaskService(ctx, sd)
+	utils.AssertNoError(t, err)
+
+	task := &testTask{
+		id:      "task",
+		ctx:     ctx,
+		service: service.(*bfTaskService),
+		stdout:  filepath.Join(dir, "stdout.log"),
+	}
+	// The open completes in the background once the shim opens the other end,
+	// the same as in containerd
+	task.stdin, err = fifo.OpenFifo(ctx, stdin, syscall.O_WRONLY|syscall.O_NONBLOCK, 0)
+	utils.AssertNoError(t, err)
+	t.Cleanup(func() { task.stdin.Close() })
+
+	_, err = task.service.Create(ctx, &taskAPI.CreateTaskRequest{
+		ID:       task.id,
+		Bundle:   bundle,
+		Terminal: terminal,
+		Stdin:    stdin,
+		Stdout:   "file://" + task.stdout,
+	})
+	utils.AssertNoError(t, err)
+	t.Cleanup(func() {
+		task.service.Kill(ctx, &taskAPI.KillRequest{ID: task.id, Signal: uint32(syscall.SIGKILL)})
+		task.service.Delete(ctx, &taskAPI.DeleteRequest{ID: task.id})
+	})
+	return task
+}
+
+func (task *testTask) start(t *testing.T) {
+	t.Helper()
+	_, err := task.service.Start(task.ctx, &taskAPI.StartRequest{ID: task.id})
+	utils.AssertNoError(t, err)
+}
+
+// Wait for the output of the task to contain s
+func (task *testTask) waitForOutput(t *testing.T, s string) {
+	t.Helper()
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(task.stdout)
+		return strings.Contains(string(data), s)
+	})
+}
+
+// Wait for the task to exit and return its exit status
+func (task *testTask) wait(t *testing.T) uint32 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(task.ctx, 5*time.Second)
+	defer cancel()
+	resp, err := task.service.Wait(ctx, &taskAPI.WaitRequest{ID: task.id})
+	utils.AssertNoError(t, err)
+	return resp.ExitStatus
+}
+
+func TestTask_Run(t *testing.T) {
+	task := createTask(t, "++++++++[>++++++++<-]>+.", false)
+	task.start(t)
+	utils.AssertEqual(t, task.wait(t), 0)
+	task.waitForOutput(t, "A")
+}
+
+func TestTask_CloseIO(t *testing.T) {
+	// Echoes its input until EOF
+	task := createTask(t, ",[.,]", false)
+	task.start(t)
+	_, err := task.stdin.Write([]byte("hi"))
+	utils.AssertNoError(t, err)
+	task.waitForOutput(t, "hi")
+
+	_, err = task.service.CloseIO(task.ctx, &taskAPI.CloseIORequest{ID: task.id, Stdin: true})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, task.wait(t), 0)
+}
+
+func TestTask_CloseIO_Terminal(t *testing.T) {
+	task := createTask(t, ",[.,]", true)
+	task.start(t)
+	_, err := task.stdin.Write([]byte("hi\n"))
+	utils.AssertNoError(t, err)
+	task.waitForOutput(t, "hi")
+
+	_, err = task.service.CloseIO(task.ctx, &taskAPI.CloseIORequest{ID: task.id, Stdin: true})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, task.wait(t), 0)
+}
+
+func TestTask_ResizePty(t *testing.T) {
+	task := createTask(t, ",", true)
+	task.start(t)
+
+	_, err := task.service.ResizePty(task.ctx, &taskAPI.ResizePtyRequest{ID: task.id, Width: 100, Height: 40})
+	utils.AssertNoError(t, err)
+	size, err := task.service.procs[task.id].console.Size()
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, size.Width, 100)
+	utils.AssertEqual(t, size.Height, 40)
+}
+
+func TestTask_ResizePty_NoTerminal(t *testing.T) {
+	task := createTask(t, ",", false)
+	task.start(t)
+
+	_, err := task.service.ResizePty(task.ctx, &taskAPI.ResizePtyRequest{ID: task.id, Width: 100, Height: 40})
+	utils.Assert(t, errdefs.IsFailedPrecondition(err), "expected a failed precondition")
+}
+
+func TestTask_Delete_ClosesStdinFifo(t *testing.T) {
+	for _, terminal := range []bool{false, true} {
+		task := createTask(t, ",", terminal)
+		task.start(t)
+		_, err := task.stdin.Write([]byte("x\n"))
+		utils.AssertNoError(t, err)
+		utils.AssertEqual(t, task.wait(t), 0)
+
+		_, err = task.service.Delete(task.ctx, &taskAPI.DeleteRequest{ID: task.id})
+		utils.AssertNoError(t, err)
+		// Nobody reads the fifo anymore
+		waitFor(t, func() bool {
+			_, err := task.stdin.Write([]byte("y"))
+			return errors.Is(err, syscall.EPIPE)
+		})
+	}
+}
+
+func TestTask_Kill(t *testing.T) {
+	// The interpreter is the init process of its PID namespace, so it only
+	// stops if it handles the signal
+	for _, sig := range process.StopSignals {
+		// Loops forever, and stops between instructions
+		task := createTask(t, "+[]", false)
+		task.start(t)
+		sig := sig.(syscall.Signal)
+		_, err := task.service.Kill(task.ctx, &taskAPI.KillRequest{ID: task.id, Signal: uint32(sig)})
+		utils.AssertNoError(t, err)
+		utils.AssertEqual(t, task.wait(t), uint32(process.SignalExitCode(sig)))
+	}
+}
+
+func TestTask_Kill_BlockedOnStdin(t *testing.T) {
+	// The interpreter doesn't notice the signal while it waits for input, so
+	// it exits once the grace period is over
+	task := createTask(t, "+.,", false)
+	task.start(t)
+	// It reads once it has written
+	task.waitForOutput(t, "\x01")
+	start := time.Now()
+	_, err := task.service.Kill(task.ctx, &taskAPI.KillRequest{ID: task.id, Signal: uint32(syscall.SIGTERM)})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, task.wait(t), uint32(process.SignalExitCode(syscall.SIGTERM)))
+	utils.Assert(t, time.Since(start) >= process.TerminationGracePeriod, "expected the grace period to pass")
+}
+
+func TestTask_Kill_All(t *testing.T) {
+	task := createTask(t, "+[]", false)
+	task.start(t)
+
+	// Another process in the process group of the init process
+	pid := task.service.procs[task.id].pid
+	other := exec.Command("sleep", "30")
+	other.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pid}
+	utils.AssertNoError(t, other.Start())
+	defer other.Process.Kill()
+
+	_, err := task.service.Kill(task.ctx, &taskAPI.KillRequest{ID: task.id, Signal: uint32(syscall.SIGTERM), All: true})
+	utils.AssertNoError(t, err)
+	utils.AssertEqual(t, task.wait(t), uint32(process.SignalExitCode(syscall.SIGTERM)))
+	other.Wait()
+	utils.AssertEqual(t, other.ProcessState.Sys().(syscall.WaitStatus).Signal(), syscall.SIGTERM)
+}
@@ -0,0 +1,260 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: shim/types/types.proto
+
+package types
+
+import (
+	stats "github.com/containerd/cgroups/v3/cgroup2/stats"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Metrics of a brainfuck task, as returned by the Stats task API
+type Metrics struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of instructions executed so far
+	Instructions uint64 `protobuf:"varint,1,opt,name=instructions,proto3" json:"instructions,omitempty"`
+	// Index of the next instruction to execute
+	ProgramCounter uint32 `protobuf:"varint,2,opt,name=program_counter,json=programCounter,proto3" json:"program_counter,omitempty"`
+	// Highest index of a memory cell the program has moved to
+	TapeHighWater uint32 `protobuf:"varint,3,opt,name=tape_high_water,json=tapeHighWater,proto3" json:"tape_high_water,omitempty"`
+	// Number of bytes read from stdin
+	BytesRead uint64 `protobuf:"varint,4,opt,name=bytes_read,json=bytesRead,proto3" json:"bytes_read,omitempty"`
+	// Number of bytes written to stdout
+	BytesWritten uint64 `protobuf:"varint,5,opt,name=bytes_written,json=bytesWritten,proto3" json:"bytes_written,omitempty"`
+	// User + system CPU time of the interpreter process in nanoseconds
+	CpuTimeNs uint64 `protobuf:"varint,6,opt,name=cpu_time_ns,json=cpuTimeNs,proto3" json:"cpu_time_ns,omitempty"`
+	// Metrics of the cgroup of the task, if it has one
+	Cgroup        *stats.Metrics `protobuf:"bytes,7,opt,name=cgroup,proto3" json:"cgroup,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metrics) Reset() {
+	*x = Metrics{}
+	mi := &file_shim_types_types_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metrics) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metrics) ProtoMessage() {}
+
+func (x *Metrics) ProtoReflect() protoreflect.Message {
+	mi := &file_shim_types_types_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metrics.ProtoReflect.Descriptor instead.
+func (*Metrics) Descriptor() ([]byte, []int) {
+	return file_shim_types_types_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Metrics) GetInstructions() uint64 {
+	if x != nil {
+		return x.Instructions
+	}
+	return 0
+}
+
+func (x *Metrics) GetProgramCounter() uint32 {
+	if x != nil {
+		return x.ProgramCounter
+	}
+	return 0
+}
+
+func (x *Metrics) GetTapeHighWater() uint32 {
+	if x != nil {
+		return x.TapeHighWater
+	}
+	return 0
+}
+
+func (x *Metrics) GetBytesRead() uint64 {
+	if x != nil {
+		return x.BytesRead
+	}
+	return 0
+}
+
+func (x *Metrics) GetBytesWritten() uint64 {
+	if x != nil {
+		return x.BytesWritten
+	}
+	return 0
+}
+
+func (x *Metrics) GetCpuTimeNs() uint64 {
+	if x != nil {
+		return x.CpuTimeNs
+	}
+	return 0
+}
+
+func (x *Metrics) GetCgroup() *stats.Metrics {
+	if x != nil {
+		return x.Cgroup
+	}
+	return nil
+}
+
+// Details of a process in a brainfuck task, as returned in the Info field of
+// the Pids task API
+type ProcessDetails struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Exec ID of the process. Empty for the init process.
+	ExecId string `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
+	// Path to the brainfuck program being run
+	Entrypoint string `protobuf:"bytes,2,opt,name=entrypoint,proto3" json:"entrypoint,omitempty"`
+	// Status of the process (created, running, stopped)
+	Status        string `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessDetails) Reset() {
+	*x = ProcessDetails{}
+	mi := &file_shim_types_types_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessDetails) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessDetails) ProtoMessage() {}
+
+func (x *ProcessDetails) ProtoReflect() protoreflect.Message {
+	mi := &file_shim_types_types_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessDetails.ProtoReflect.Descriptor instead.
+func (*ProcessDetails) Descriptor() ([]byte, []int) {
+	return file_shim_types_types_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ProcessDetails) GetExecId() string {
+	if x != nil {
+		return x.ExecId
+	}
+	return ""
+}
+
+func (x *ProcessDetails) GetEntrypoint() string {
+	if x != nil {
+		return x.Entrypoint
+	}
+	return ""
+}
+
+func (x *ProcessDetails) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+var File_shim_types_types_proto protoreflect.FileDescriptor
+
+const file_shim_types_types_proto_rawDesc = "" +
+	"\n" +
+	"\x16shim/types/types.proto\x12\x10containerd.bf.v1\x1a9github.com/containerd/cgroups/cgroup2/stats/metrics.proto\"\x9d\x02\n" +
+	"\aMetrics\x12\"\n" +
+	"\finstructions\x18\x01 \x01(\x04R\finstructions\x12'\n" +
+	"\x0fprogram_counter\x18\x02 \x01(\rR\x0eprogramCounter\x12&\n" +
+	"\x0ftape_high_water\x18\x03 \x01(\rR\rtapeHighWater\x12\x1d\n" +
+	"\n" +
+	"bytes_read\x18\x04 \x01(\x04R\tbytesRead\x12#\n" +
+	"\rbytes_written\x18\x05 \x01(\x04R\fbytesWritten\x12\x1e\n" +
+	"\vcpu_time_ns\x18\x06 \x01(\x04R\tcpuTimeNs\x129\n" +
+	"\x06cgroup\x18\a \x01(\v2!.io.containerd.cgroups.v2.MetricsR\x06cgroup\"a\n" +
+	"\x0eProcessDetails\x12\x17\n" +
+	"\aexec_id\x18\x01 \x01(\tR\x06execId\x12\x1e\n" +
+	"\n" +
+	"entrypoint\x18\x02 \x01(\tR\n" +
+	"entrypoint\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06statusB5Z3github.com/MarcinKonowalczyk/runbf/shim/types;typesb\x06proto3"
+
+var (
+	file_shim_types_types_proto_rawDescOnce sync.Once
+	file_shim_types_types_proto_rawDescData []byte
+)
+
+func file_shim_types_types_proto_rawDescGZIP() []byte {
+	file_shim_types_types_proto_rawDescOnce.Do(func() {
+		file_shim_types_types_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_shim_types_types_proto_rawDesc), len(file_shim_types_types_proto_rawDesc)))
+	})
+	return file_shim_types_types_proto_rawDescData
+}
+
+var file_shim_types_types_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_shim_types_types_proto_goTypes = []any{
+	(*Metrics)(nil),        // 0: containerd.bf.v1.Metrics
+	(*ProcessDetails)(nil), // 1: containerd.bf.v1.ProcessDetails
+	(*stats.Metrics)(nil),  // 2: io.containerd.cgroups.v2.Metrics
+}
+var file_shim_types_types_proto_depIdxs = []int32{
+	2, // 0: containerd.bf.v1.Metrics.cgroup:type_name -> io.containerd.cgroups.v2.Metrics
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_shim_types_types_proto_init() }
+func file_shim_types_types_proto_init() {
+	if File_shim_types_types_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_shim_types_types_proto_rawDesc), len(file_shim_types_types_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_shim_types_types_proto_goTypes,
+		DependencyIndexes: file_shim_types_types_proto_depIdxs,
+		MessageInfos:      file_shim_types_types_proto_msgTypes,
+	}.Build()
+	File_shim_types_types_proto = out.File
+	file_shim_types_types_proto_goTypes = nil
+	file_shim_types_types_proto_depIdxs = nil
+}
@@ -0,0 +1,21 @@
+package shim
+
+import (
+	"os"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Credentials to run the init process with, or nil if it runs as the same user
+// as the shim
+func credential(user specs.User) *syscall.Credential {
+	if int(user.UID) == os.Getuid() && int(user.GID) == os.Getgid() && len(user.AdditionalGids) == 0 {
+		return nil
+	}
+	return &syscall.Credential{
+		Uid:    user.UID,
+		Gid:    user.GID,
+		Groups: user.AdditionalGids,
+	}
+}
@@ -0,0 +1,18 @@
+package shim
+
+import (
+	"testing"
+
+	"github.com/MarcinKonowalczyk/runbf/utils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestCredential(t *testing.T) {
+	utils.Assert(t, credential(specs.User{UID: uint32(unix.Getuid()), GID: uint32(unix.Getgid())}) == nil, "expected no credential for the current user")
+
+	cred := credential(specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{10}})
+	utils.AssertEqual(t, cred.Uid, 1000)
+	utils.AssertEqual(t, cred.Gid, 1000)
+	utils.AssertEqual(t, len(cred.Groups), 1)
+}